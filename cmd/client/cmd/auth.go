@@ -18,6 +18,7 @@ import (
 	"github.com/fiatjaf/go-lnurl"
 	"github.com/spf13/cobra"
 	"github.com/sunboyy/lnurlauth/pkg"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
 	"github.com/tyler-smith/go-bip32"
 	"github.com/tyler-smith/go-bip39"
 )
@@ -38,10 +39,12 @@ var authCmd = &cobra.Command{
 	Short: "performs lnurl authentication",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+
 		// Read mnemonic from mnemonic.txt file and convert to seed.
 		seed, err := seedFromMnemonicFile()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "mnemonic: %s\n", err.Error())
+			fmt.Fprintf(errOut, "mnemonic: %s\n", err.Error())
 			return
 		}
 
@@ -50,7 +53,7 @@ var authCmd = &cobra.Command{
 		// Extract auth URL from LNURL.
 		authURL, err := extractLNURL(lnurlBech32)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "extractLNURL: %s\n", err.Error())
+			fmt.Fprintf(errOut, "extractLNURL: %s\n", err.Error())
 			return
 		}
 
@@ -58,45 +61,56 @@ var authCmd = &cobra.Command{
 		// that the wallet app knows that this is an auth URL.
 		tag := authURL.Query().Get("tag")
 		if tag != "login" {
-			fmt.Fprintf(os.Stderr, "lnurl: url is not used for authentication")
+			fmt.Fprintf(errOut, "lnurl: url is not used for authentication\n")
+			return
+		}
+
+		// The optional action tells the user what the service is going to
+		// do with the signature.
+		action := authURL.Query().Get("action")
+		if !lnurlauth.Action(action).Valid() {
+			fmt.Fprintf(errOut, "lnurl: unknown action '%s'\n", action)
 			return
 		}
 
 		k1Hex := authURL.Query().Get("k1")
 		k1Bytes, err := hex.DecodeString(k1Hex)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "decode k1: %s\n", err.Error())
+			fmt.Fprintf(errOut, "decode k1: %s\n", err.Error())
 			return
 		}
 
-		fmt.Println("LNURL information:")
-		fmt.Printf("  Auth URL = %s\n", authURL.String())
-		fmt.Printf("  Hostname = %s\n", authURL.Hostname())
-		fmt.Printf("  Challenge = %s\n", k1Hex)
+		fmt.Fprintln(out, "LNURL information:")
+		fmt.Fprintf(out, "  Auth URL = %s\n", authURL.String())
+		fmt.Fprintf(out, "  Hostname = %s\n", authURL.Hostname())
+		fmt.Fprintf(out, "  Challenge = %s\n", k1Hex)
+		if action != "" {
+			fmt.Fprintf(out, "  Action = %s\n", action)
+		}
 
 		// Derive key pair from seed and domain to log in
 		privateKey, publicKey := deriveLinkingKey(seed, authURL.Hostname())
 
 		linkingKey := publicKey.SerializeCompressed()
 		signature, _ := privateKey.ToECDSA().Sign(rand.Reader, k1Bytes, nil)
-		fmt.Println("Identity information:")
-		fmt.Printf("  Linking key = %s\n", hex.EncodeToString(linkingKey))
-		fmt.Printf("  Signature = %s\n", hex.EncodeToString(signature))
+		fmt.Fprintln(out, "Identity information:")
+		fmt.Fprintf(out, "  Linking key = %s\n", hex.EncodeToString(linkingKey))
+		fmt.Fprintf(out, "  Signature = %s\n", hex.EncodeToString(signature))
 
 		query := authURL.Query()
 		query.Add("sig", hex.EncodeToString(signature))
 		query.Add("key", hex.EncodeToString(linkingKey))
 		authURL.RawQuery = query.Encode()
-		fmt.Printf("  Authed URL = %s\n", authURL.String())
+		fmt.Fprintf(out, "  Authed URL = %s\n", authURL.String())
 
 		if !*dryRunPtr {
 			// Request authentication to the server.
 			if err := requestAuth(authURL); err != nil {
-				fmt.Fprintf(os.Stderr, "requestAuth: %s\n", err.Error())
+				fmt.Fprintf(errOut, "requestAuth: %s\n", err.Error())
 				return
 			}
 
-			fmt.Println("✅ Authentication success")
+			fmt.Fprintln(out, "✅ Authentication success")
 		}
 	},
 }
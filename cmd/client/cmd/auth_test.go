@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// runAuth runs the auth command in dry-run mode on the LNURL of the auth URL
+// and returns its output and error output. The mnemonic is generated in a
+// temporary working directory.
+func runAuth(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	entropy, err := bip39.NewEntropy(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(
+		mnemonicFileName,
+		[]byte(mnemonic),
+		0o600,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := lnurl.LNURLEncode(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errOut bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetErr(&errOut)
+	rootCmd.SetArgs([]string{"auth", "--dry-run", code})
+	defer rootCmd.SetArgs(nil)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.String(), errOut.String()
+}
+
+func TestAuthShowsAction(t *testing.T) {
+	const authURL = "https://example.com/login?tag=login&k1=" +
+		"e2af6254a8df433264fa23f67eb8188635d15ce883e8fc020989d5f82ae6f11e"
+
+	out, errOut := runAuth(t, authURL+"&action=link")
+	if errOut != "" {
+		t.Fatalf("unexpected error output %q", errOut)
+	}
+	if !strings.Contains(out, "  Action = link\n") ||
+		!strings.Contains(out, "  Authed URL = ") {
+		t.Fatalf("expected the action to be shown, got %q", out)
+	}
+
+	// The action is optional.
+	out, errOut = runAuth(t, authURL)
+	if errOut != "" {
+		t.Fatalf("unexpected error output %q", errOut)
+	}
+	if strings.Contains(out, "Action =") ||
+		!strings.Contains(out, "  Authed URL = ") {
+		t.Fatalf("expected no action to be shown, got %q", out)
+	}
+}
+
+func TestAuthRejectsUnknownAction(t *testing.T) {
+	out, errOut := runAuth(
+		t,
+		"https://example.com/login?tag=login&action=delete&k1="+
+			"e2af6254a8df433264fa23f67eb8188635d15ce883e8fc020989d5f82ae6f11e",
+	)
+	if errOut != "lnurl: unknown action 'delete'\n" {
+		t.Fatalf("expected unknown action error, got %q", errOut)
+	}
+
+	// Nothing is signed for an unknown action.
+	if out != "" {
+		t.Fatalf("expected no output, got %q", out)
+	}
+}
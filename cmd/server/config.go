@@ -0,0 +1,640 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is the prefix of the environment variables overriding the
+// configuration. The name of the variable of a flag is the prefix followed by
+// the flag name in upper case with dashes replaced by underscores, e.g.
+// LNURLAUTH_COOKIE_SAMESITE for --cookie-samesite.
+const envPrefix = "LNURLAUTH_"
+
+// Secrets have no flags, since the command line can be read by other users of
+// the host. They are set in the configuration file or by these environment
+// variables only.
+const (
+	envChallengeSecret = envPrefix + "CHALLENGE_SECRET"
+	envWebhookSecret   = envPrefix + "WEBHOOK_SECRET"
+
+	// envOIDCClients lists the OpenID Connect clients, which contain their
+	// secrets, separated by whitespace.
+	envOIDCClients = envPrefix + "OIDC_CLIENTS"
+)
+
+const (
+	storeBackendMemory = "memory"
+	storeBackendFile   = "file"
+)
+
+// config is the configuration of the server. It is read from the YAML
+// configuration file, then overridden by the environment variables and
+// finally by the command-line flags.
+type config struct {
+	// Listen is the TCP address on which the server listens.
+	Listen string `yaml:"listen"`
+
+	// Hostname is the public base URL of the server (e.g.
+	// https://example.com), which is embedded in the LNURLs.
+	Hostname string `yaml:"hostname"`
+
+	// ShutdownTimeout is the time given to the in-flight requests to finish
+	// after the server is asked to stop.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+
+	// TrustedProxies are the IP addresses and CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For and X-Real-IP headers are trusted.
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	Store      storeConfig      `yaml:"store"`
+	Challenge  challengeConfig  `yaml:"challenge"`
+	RateLimits rateLimitsConfig `yaml:"rateLimits"`
+	Cookie     cookieConfig     `yaml:"cookie"`
+	Session    sessionConfig    `yaml:"session"`
+	Tokens     tokensConfig     `yaml:"tokens"`
+	OIDC       oidcConfig       `yaml:"oidc"`
+	TLS        tlsConfig        `yaml:"tls"`
+	AuditLog   auditLogConfig   `yaml:"auditLog"`
+	Metrics    metricsConfig    `yaml:"metrics"`
+	Webhook    webhookConfig    `yaml:"webhook"`
+}
+
+// storeConfig configures the storage of sessions and challenges.
+type storeConfig struct {
+	// Backend is either `memory` or `file`. If it is empty, the file backend
+	// is used when File is set.
+	Backend string `yaml:"backend"`
+
+	// File is the file persisting sessions across restarts.
+	File string `yaml:"file"`
+}
+
+// challengeConfig configures the k1 challenges.
+type challengeConfig struct {
+	TTL    time.Duration `yaml:"ttl"`
+	Max    int           `yaml:"max"`
+	Secret string        `yaml:"secret"`
+}
+
+// rateLimitsConfig configures the rate limits in the format <count>/<period>.
+type rateLimitsConfig struct {
+	Callbacks        rateLimitFlag `yaml:"callbacks"`
+	FailedSignatures rateLimitFlag `yaml:"failedSignatures"`
+	Challenges       rateLimitFlag `yaml:"challenges"`
+}
+
+// cookieConfig configures the session cookie.
+type cookieConfig struct {
+	Name     string `yaml:"name"`
+	Domain   string `yaml:"domain"`
+	Path     string `yaml:"path"`
+	Secure   bool   `yaml:"secure"`
+	SameSite string `yaml:"sameSite"`
+}
+
+// sessionConfig configures the session timeouts.
+type sessionConfig struct {
+	IdleTimeout     time.Duration `yaml:"idleTimeout"`
+	AbsoluteTimeout time.Duration `yaml:"absoluteTimeout"`
+}
+
+// tokensConfig configures the bearer tokens.
+type tokensConfig struct {
+	// SigningKey is the PEM file of the key signing the tokens. Bearer
+	// tokens are disabled if it is empty.
+	SigningKey string        `yaml:"signingKey"`
+	TTL        time.Duration `yaml:"ttl"`
+}
+
+// enabled reports whether bearer tokens are issued.
+func (c tokensConfig) enabled() bool {
+	return c.SigningKey != ""
+}
+
+// oidcConfig configures the OpenID Connect provider.
+type oidcConfig struct {
+	Clients    oidcClientList `yaml:"clients"`
+	SigningKey string         `yaml:"signingKey"`
+}
+
+// tlsConfig configures HTTPS. HTTPS is enabled if both files are set or a
+// self-signed certificate is requested.
+type tlsConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+
+	// SelfSigned generates a self-signed certificate on start, which is meant
+	// for trying wallets on a local network.
+	SelfSigned bool `yaml:"selfSigned"`
+
+	// RedirectListen is the TCP address of a plain HTTP listener redirecting
+	// to HTTPS. The redirect is disabled if it is empty.
+	RedirectListen string `yaml:"redirectListen"`
+}
+
+// enabled reports whether the server is served over HTTPS.
+func (c tlsConfig) enabled() bool {
+	return c.CertFile != "" || c.SelfSigned
+}
+
+// auditLogConfig configures the audit log.
+type auditLogConfig struct {
+	Path string `yaml:"path"`
+
+	// MaxSize is the size in megabytes at which the audit log is rotated.
+	MaxSize    int64 `yaml:"maxSize"`
+	MaxBackups int   `yaml:"maxBackups"`
+}
+
+// metricsConfig configures the Prometheus metrics endpoint.
+type metricsConfig struct {
+	Path string `yaml:"path"`
+}
+
+// webhookConfig configures the webhook notifications.
+type webhookConfig struct {
+	URL            string `yaml:"url"`
+	Secret         string `yaml:"secret"`
+	MaxAttempts    int    `yaml:"maxAttempts"`
+	DeadLetterFile string `yaml:"deadLetterFile"`
+}
+
+// defaultConfig returns the configuration used when nothing is given.
+func defaultConfig() config {
+	return config{
+		Listen:          ":8080",
+		ShutdownTimeout: time.Second * 30,
+		Challenge: challengeConfig{
+			TTL: time.Minute * 5,
+			Max: 10000,
+		},
+		RateLimits: rateLimitsConfig{
+			Callbacks:        rateLimitFlag{Limit: 30, Period: time.Minute},
+			FailedSignatures: rateLimitFlag{Limit: 5, Period: time.Minute},
+			Challenges:       rateLimitFlag{Limit: 60, Period: time.Minute},
+		},
+		Cookie: cookieConfig{
+			Name:     "lnurl_sess",
+			Path:     "/",
+			SameSite: "lax",
+		},
+		Session: sessionConfig{
+			IdleTimeout:     time.Hour,
+			AbsoluteTimeout: time.Hour * 24,
+		},
+		Tokens: tokensConfig{
+			TTL: time.Minute * 15,
+		},
+		AuditLog: auditLogConfig{
+			MaxSize:    100,
+			MaxBackups: 5,
+		},
+		Metrics: metricsConfig{
+			Path: "/metrics",
+		},
+		Webhook: webhookConfig{
+			MaxAttempts: 5,
+		},
+	}
+}
+
+// loadConfig loads the configuration from the configuration file given by
+// --config (or LNURLAUTH_CONFIG), the environment variables and the
+// command-line arguments, in increasing order of precedence, and validates
+// it. flag.ErrHelp is returned if the usage is requested.
+func loadConfig(args []string) (config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String(
+		"config",
+		os.Getenv(envPrefix+"CONFIG"),
+		"YAML configuration file",
+	)
+	cfg.bindFlags(fs)
+
+	// The first pass only finds the configuration file. The flags are parsed
+	// again afterwards so that they take precedence.
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	cfg = defaultConfig()
+
+	if *configPath != "" {
+		dat, err := os.ReadFile(*configPath)
+		if err != nil {
+			return config{}, err
+		}
+		if err := yaml.UnmarshalStrict(dat, &cfg); err != nil {
+			return config{}, fmt.Errorf("%s: %w", *configPath, err)
+		}
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok || f.Name == "config" || envErr != nil {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("%s: %w", name, err)
+		}
+	})
+	if envErr != nil {
+		return config{}, envErr
+	}
+	if err := cfg.loadSecretEnv(); err != nil {
+		return config{}, err
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return config{}, err
+	}
+
+	return cfg, nil
+}
+
+// envName returns the name of the environment variable of the flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadSecretEnv sets the secrets given by the environment variables. The
+// OpenID Connect clients of the variable replace those of the file.
+func (c *config) loadSecretEnv() error {
+	if value, ok := os.LookupEnv(envChallengeSecret); ok {
+		c.Challenge.Secret = value
+	}
+	if value, ok := os.LookupEnv(envWebhookSecret); ok {
+		c.Webhook.Secret = value
+	}
+	if value, ok := os.LookupEnv(envOIDCClients); ok {
+		var clients oidcClientList
+		for _, client := range strings.Fields(value) {
+			if err := clients.add(client); err != nil {
+				return fmt.Errorf("%s: %w", envOIDCClients, err)
+			}
+		}
+		c.OIDC.Clients = clients
+	}
+
+	return nil
+}
+
+// bindFlags defines the command-line flags setting the fields of the
+// configuration. The current values are the defaults of the flags.
+func (c *config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(
+		&c.Listen,
+		"listen",
+		c.Listen,
+		"TCP address to listen on (e.g. :8080)",
+	)
+	fs.Func(
+		"port",
+		"TCP port to listen on on all interfaces (shorthand for --listen)",
+		func(value string) error {
+			c.Listen = ":" + value
+			return nil
+		},
+	)
+	fs.StringVar(
+		&c.Hostname,
+		"hostname",
+		c.Hostname,
+		"Public base URL of the server (e.g. http://192.168.1.10:8080)",
+	)
+	fs.DurationVar(
+		&c.ShutdownTimeout,
+		"shutdown-timeout",
+		c.ShutdownTimeout,
+		"Time given to in-flight requests to finish on SIGINT or SIGTERM",
+	)
+	fs.Func(
+		"trusted-proxies",
+		"Comma-separated IP addresses and CIDR ranges of reverse proxies "+
+			"whose X-Forwarded-For and X-Real-IP headers are trusted",
+		func(value string) error {
+			c.TrustedProxies = nil
+			for _, proxy := range strings.Split(value, ",") {
+				if proxy = strings.TrimSpace(proxy); proxy != "" {
+					c.TrustedProxies = append(c.TrustedProxies, proxy)
+				}
+			}
+			return nil
+		},
+	)
+	fs.StringVar(
+		&c.Store.Backend,
+		"store",
+		c.Store.Backend,
+		"Storage of sessions and challenges (memory or file, "+
+			"file if --store-file is set)",
+	)
+	fs.StringVar(
+		&c.Store.File,
+		"store-file",
+		c.Store.File,
+		"File to persist sessions across restarts",
+	)
+	fs.DurationVar(
+		&c.Challenge.TTL,
+		"challenge-ttl",
+		c.Challenge.TTL,
+		"Duration in which a k1 challenge can be used after issued",
+	)
+	fs.IntVar(
+		&c.Challenge.Max,
+		"max-challenges",
+		c.Challenge.Max,
+		"Maximum number of outstanding k1 challenges",
+	)
+	fs.Var(
+		&c.RateLimits.Callbacks,
+		"rate-limit-callbacks",
+		"Login callbacks allowed per IP address as <count>/<period>",
+	)
+	fs.Var(
+		&c.RateLimits.FailedSignatures,
+		"rate-limit-failed-signatures",
+		"Failed signatures allowed per k1 challenge as <count>/<period>",
+	)
+	fs.Var(
+		&c.RateLimits.Challenges,
+		"rate-limit-challenges",
+		"Requests creating challenges allowed per IP address as "+
+			"<count>/<period>",
+	)
+	fs.StringVar(
+		&c.OIDC.SigningKey,
+		"oidc-signing-key",
+		c.OIDC.SigningKey,
+		"PEM file of the P-256 key signing ID tokens (generated if not set)",
+	)
+	fs.StringVar(
+		&c.Tokens.SigningKey,
+		"token-signing-key",
+		c.Tokens.SigningKey,
+		"PEM file of the P-256 or Ed25519 key signing access tokens "+
+			"(bearer tokens are disabled if not set)",
+	)
+	fs.DurationVar(
+		&c.Tokens.TTL,
+		"token-ttl",
+		c.Tokens.TTL,
+		"Lifetime of access tokens",
+	)
+	fs.StringVar(
+		&c.Cookie.Name,
+		"cookie-name",
+		c.Cookie.Name,
+		"Name of the session cookie",
+	)
+	fs.StringVar(
+		&c.Cookie.Domain,
+		"cookie-domain",
+		c.Cookie.Domain,
+		"Domain of the session cookie (host-only if not set)",
+	)
+	fs.StringVar(
+		&c.Cookie.Path,
+		"cookie-path",
+		c.Cookie.Path,
+		"Path of the session cookie",
+	)
+	fs.BoolVar(
+		&c.Cookie.Secure,
+		"cookie-secure",
+		c.Cookie.Secure,
+		"Restrict the session cookie to HTTPS (always set for HTTPS hostnames)",
+	)
+	fs.StringVar(
+		&c.Cookie.SameSite,
+		"cookie-samesite",
+		c.Cookie.SameSite,
+		"SameSite attribute of the session cookie (lax, strict or none)",
+	)
+	fs.DurationVar(
+		&c.Session.IdleTimeout,
+		"session-idle-timeout",
+		c.Session.IdleTimeout,
+		"Duration of inactivity after which a session is signed out",
+	)
+	fs.DurationVar(
+		&c.Session.AbsoluteTimeout,
+		"session-absolute-timeout",
+		c.Session.AbsoluteTimeout,
+		"Duration after signing in after which a session is signed out",
+	)
+	fs.StringVar(
+		&c.TLS.CertFile,
+		"tls-cert",
+		c.TLS.CertFile,
+		"PEM file of the TLS certificate (HTTPS is enabled with --tls-key)",
+	)
+	fs.StringVar(
+		&c.TLS.KeyFile,
+		"tls-key",
+		c.TLS.KeyFile,
+		"PEM file of the TLS private key",
+	)
+	fs.BoolVar(
+		&c.TLS.SelfSigned,
+		"tls-self-signed",
+		c.TLS.SelfSigned,
+		"Serve HTTPS with a self-signed certificate generated on start "+
+			"(for development)",
+	)
+	fs.StringVar(
+		&c.TLS.RedirectListen,
+		"tls-redirect-listen",
+		c.TLS.RedirectListen,
+		"TCP address of a plain HTTP listener redirecting to HTTPS "+
+			"(e.g. :80, disabled if empty)",
+	)
+	fs.StringVar(
+		&c.AuditLog.Path,
+		"audit-log",
+		c.AuditLog.Path,
+		"Path to the JSON Lines audit log (disabled if empty)",
+	)
+	fs.Int64Var(
+		&c.AuditLog.MaxSize,
+		"audit-log-max-size",
+		c.AuditLog.MaxSize,
+		"Size in megabytes at which the audit log is rotated",
+	)
+	fs.IntVar(
+		&c.AuditLog.MaxBackups,
+		"audit-log-max-backups",
+		c.AuditLog.MaxBackups,
+		"Number of rotated audit logs to keep",
+	)
+	fs.StringVar(
+		&c.Metrics.Path,
+		"metrics-path",
+		c.Metrics.Path,
+		"Path of the Prometheus metrics endpoint (disabled if empty)",
+	)
+	fs.StringVar(
+		&c.Webhook.URL,
+		"webhook-url",
+		c.Webhook.URL,
+		"URL notified on every login and logout (disabled if empty)",
+	)
+	fs.IntVar(
+		&c.Webhook.MaxAttempts,
+		"webhook-max-attempts",
+		c.Webhook.MaxAttempts,
+		"Number of attempts to deliver a webhook notification",
+	)
+	fs.StringVar(
+		&c.Webhook.DeadLetterFile,
+		"webhook-dead-letter-file",
+		c.Webhook.DeadLetterFile,
+		"Path to the JSON Lines file of undelivered webhook notifications",
+	)
+}
+
+// validate checks the configuration and reports all problems at once. The
+// store backend is resolved if it is not set.
+func (c *config) validate() error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Listen == "" {
+		addProblem("listen address is required")
+	}
+
+	if c.Hostname == "" {
+		addProblem("hostname is required")
+	} else if u, err := url.Parse(c.Hostname); err != nil ||
+		(u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" {
+		addProblem("hostname must be an http or https URL")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		addProblem("shutdown timeout must be positive")
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				addProblem("trusted proxy %q must be an IP address or a "+
+					"CIDR range", proxy)
+			}
+		}
+	}
+
+	if c.Store.Backend == "" {
+		c.Store.Backend = storeBackendMemory
+		if c.Store.File != "" {
+			c.Store.Backend = storeBackendFile
+		}
+	}
+	switch c.Store.Backend {
+	case storeBackendMemory:
+		if c.Store.File != "" {
+			addProblem("store file requires the file store backend")
+		}
+	case storeBackendFile:
+		if c.Store.File == "" {
+			addProblem("file store backend requires a store file")
+		}
+	default:
+		addProblem("store backend must be memory or file")
+	}
+
+	if c.Challenge.TTL <= 0 {
+		addProblem("challenge ttl must be positive")
+	}
+	if c.Challenge.Max <= 0 {
+		addProblem("max challenges must be positive")
+	}
+
+	if c.Cookie.Name == "" {
+		addProblem("cookie name is required")
+	}
+	if _, err := parseSameSite(c.Cookie.SameSite); err != nil {
+		addProblem("cookie samesite %s", err.Error())
+	}
+
+	if c.Session.IdleTimeout <= 0 || c.Session.AbsoluteTimeout <= 0 {
+		addProblem("session timeouts must be positive")
+	} else if c.Session.AbsoluteTimeout < c.Session.IdleTimeout {
+		addProblem("session absolute timeout must not be shorter than " +
+			"idle timeout")
+	}
+
+	if c.Tokens.TTL <= 0 {
+		addProblem("token ttl must be positive")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		addProblem("tls certificate and key must be given together")
+	}
+	if c.TLS.SelfSigned && c.TLS.CertFile != "" {
+		addProblem("tls self-signed certificate cannot be combined with " +
+			"certificate files")
+	}
+	if c.TLS.enabled() && !strings.HasPrefix(c.Hostname, "https://") {
+		addProblem("hostname must be an https URL when tls is enabled")
+	}
+	if c.TLS.RedirectListen != "" {
+		if !c.TLS.enabled() {
+			addProblem("tls redirect requires tls to be enabled")
+		} else if c.TLS.RedirectListen == c.Listen {
+			addProblem("tls redirect must listen on another address")
+		}
+	}
+
+	if c.AuditLog.Path != "" {
+		if c.AuditLog.MaxSize <= 0 {
+			addProblem("audit log max size must be positive")
+		}
+		if c.AuditLog.MaxBackups < 0 {
+			addProblem("audit log max backups must not be negative")
+		}
+	}
+
+	if c.Metrics.Path != "" && !strings.HasPrefix(c.Metrics.Path, "/") {
+		addProblem("metrics path must start with /")
+	}
+
+	if c.Webhook.URL != "" {
+		if u, err := url.Parse(c.Webhook.URL); err != nil ||
+			(u.Scheme != "http" && u.Scheme != "https") ||
+			u.Host == "" {
+			addProblem("webhook url must be an http or https URL")
+		}
+		if c.Webhook.Secret == "" {
+			addProblem("webhook secret is required (%s)", envWebhookSecret)
+		}
+		if c.Webhook.MaxAttempts <= 0 {
+			addProblem("webhook max attempts must be positive")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
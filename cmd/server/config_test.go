@@ -0,0 +1,185 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/oidc"
+)
+
+// writeConfigFile writes the YAML configuration to a temporary file and
+// returns its path.
+func writeConfigFile(t *testing.T, yaml string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+hostname: https://file.example.com
+listen: ":1"
+challenge:
+  ttl: 1m
+  secret: file-secret
+cookie:
+  name: file_sess
+  path: /file
+webhook:
+  url: https://hooks.example.com
+  secret: file-webhook-secret
+oidc:
+  clients:
+    - file-app:file-secret:https://file.example.com/callback
+`)
+	t.Setenv("LNURLAUTH_LISTEN", ":2")
+	t.Setenv("LNURLAUTH_COOKIE_NAME", "env_sess")
+	t.Setenv("LNURLAUTH_COOKIE_PATH", "/env")
+	t.Setenv("LNURLAUTH_CHALLENGE_SECRET", "env-secret")
+	t.Setenv(
+		"LNURLAUTH_OIDC_CLIENTS",
+		"app:secret:https://a.example.com/cb "+
+			"app:secret:https://b.example.com/cb",
+	)
+
+	cfg, err := loadConfig([]string{
+		"--config", path,
+		"--listen", ":3",
+		"--cookie-path", "/flag",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags override the environment, which overrides the file, which
+	// overrides the defaults.
+	for name, values := range map[string][2]interface{}{
+		"hostname":         {cfg.Hostname, "https://file.example.com"},
+		"listen":           {cfg.Listen, ":3"},
+		"challenge ttl":    {cfg.Challenge.TTL, time.Minute},
+		"max challenges":   {cfg.Challenge.Max, 10000},
+		"challenge secret": {cfg.Challenge.Secret, "env-secret"},
+		"cookie name":      {cfg.Cookie.Name, "env_sess"},
+		"cookie path":      {cfg.Cookie.Path, "/flag"},
+		"webhook secret":   {cfg.Webhook.Secret, "file-webhook-secret"},
+		"tokens enabled":   {cfg.Tokens.enabled(), false},
+	} {
+		if values[0] != values[1] {
+			t.Errorf("expected %s %v, got %v", name, values[1], values[0])
+		}
+	}
+
+	expectedClients := oidcClientList{{
+		ID:     "app",
+		Secret: "secret",
+		RedirectURIs: []string{
+			"https://a.example.com/cb",
+			"https://b.example.com/cb",
+		},
+	}}
+	if !reflect.DeepEqual(cfg.OIDC.Clients, expectedClients) {
+		t.Errorf(
+			"expected clients %+v, got %+v",
+			expectedClients,
+			cfg.OIDC.Clients,
+		)
+	}
+}
+
+func TestLoadConfigRejectsSecretFlags(t *testing.T) {
+	for _, flag := range []string{
+		"--challenge-secret",
+		"--webhook-secret",
+		"--oidc-client",
+	} {
+		if _, err := loadConfig([]string{
+			"--hostname", "http://localhost:8080",
+			flag, "secret",
+		}); err == nil {
+			t.Errorf("expected %s to be rejected", flag)
+		}
+	}
+}
+
+func TestLoadConfigRejectsUnknownKeys(t *testing.T) {
+	path := writeConfigFile(t, `
+hostname: http://localhost:8080
+cookie:
+  sameSit: strict
+`)
+
+	_, err := loadConfig([]string{"--config", path})
+	if err == nil || !strings.Contains(err.Error(), "sameSit") {
+		t.Fatalf("expected unknown key to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfigRejectsInvalidEnvironment(t *testing.T) {
+	t.Setenv("LNURLAUTH_CHALLENGE_TTL", "five minutes")
+
+	_, err := loadConfig([]string{"--hostname", "http://localhost:8080"})
+	if err == nil ||
+		!strings.Contains(err.Error(), "LNURLAUTH_CHALLENGE_TTL") {
+		t.Fatalf("expected invalid variable to be reported, got %v", err)
+	}
+
+	t.Setenv("LNURLAUTH_CHALLENGE_TTL", "5m")
+	t.Setenv("LNURLAUTH_OIDC_CLIENTS", "app-without-secret")
+	_, err = loadConfig([]string{"--hostname", "http://localhost:8080"})
+	if err == nil || !strings.Contains(err.Error(), "LNURLAUTH_OIDC_CLIENTS") {
+		t.Fatalf("expected invalid client to be reported, got %v", err)
+	}
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	_, err := loadConfig([]string{
+		"--hostname", "ftp://localhost",
+		"--challenge-ttl", "0s",
+		"--session-idle-timeout", "2h",
+		"--session-absolute-timeout", "1h",
+		"--tls-cert", "cert.pem",
+		"--trusted-proxies", "10.0.0.0/33",
+		"--webhook-url", "https://hooks.example.com",
+	})
+	if err == nil {
+		t.Fatal("expected invalid configuration to be rejected")
+	}
+
+	for _, problem := range []string{
+		"hostname must be an http or https URL",
+		"challenge ttl must be positive",
+		"session absolute timeout must not be shorter than idle timeout",
+		"tls certificate and key must be given together",
+		`trusted proxy "10.0.0.0/33"`,
+		"webhook secret is required (LNURLAUTH_WEBHOOK_SECRET)",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected problem %q in %q", problem, err.Error())
+		}
+	}
+}
+
+func TestOIDCClientListRejectsConflictingSecrets(t *testing.T) {
+	var clients oidcClientList
+	if err := clients.add("app:secret:https://a.example.com/cb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clients.add("app:other:https://b.example.com/cb"); err == nil {
+		t.Fatal("expected conflicting secret to be rejected")
+	}
+	if !reflect.DeepEqual(clients, oidcClientList{oidc.Client{
+		ID:           "app",
+		Secret:       "secret",
+		RedirectURIs: []string{"https://a.example.com/cb"},
+	}}) {
+		t.Fatalf("unexpected clients %+v", clients)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// parseSameSite parses the value of the --cookie-samesite flag.
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, errors.New("must be lax, strict or none")
+	}
+}
@@ -1,42 +1,213 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/oidc"
 )
 
 //go:embed templates/*
 var f embed.FS
 
 func main() {
-	portPtr := flag.Int("port", 8080, "TCP port to run")
-	hostnamePtr := flag.String(
-		"hostname",
-		"",
-		"Hostname of the server (e.g. http://192.168.1.10:8080)",
+	if err := run(os.Args[1:]); err != nil {
+		// The usage has already been printed.
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal(err)
+	}
+}
+
+// run configures the server from the command line arguments and runs it until
+// it is stopped. The errors are returned instead of exiting the process so
+// that the deferred closes, such as writing the final snapshot of the file
+// store, always run.
+func run(args []string) error {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	// Setup storage for sessions and challenges.
+	authOpts := []lnurlauth.Option{}
+	if cfg.Store.Backend == storeBackendFile {
+		fileStore, err := lnurlauth.NewFileStore(cfg.Store.File)
+		if err != nil {
+			return fmt.Errorf("file store: %w", err)
+		}
+		// The final snapshot is written once the server has drained its
+		// requests.
+		defer func() {
+			if err := fileStore.Close(); err != nil {
+				log.Printf("file store: %s", err.Error())
+			}
+		}()
+
+		authOpts = append(authOpts, lnurlauth.WithStore(fileStore))
+	}
+
+	// Setup session cookie and session timeouts.
+	sameSite, err := parseSameSite(cfg.Cookie.SameSite)
+	if err != nil {
+		return fmt.Errorf("cookie samesite: %w", err)
+	}
+	authOpts = append(
+		authOpts,
+		lnurlauth.WithCookie(lnurlauth.CookieConfig{
+			Name:   cfg.Cookie.Name,
+			Domain: cfg.Cookie.Domain,
+			Path:   cfg.Cookie.Path,
+			Secure: cfg.Cookie.Secure ||
+				strings.HasPrefix(cfg.Hostname, "https://"),
+			SameSite: sameSite,
+		}),
+		lnurlauth.WithSessionTimeouts(
+			cfg.Session.IdleTimeout,
+			cfg.Session.AbsoluteTimeout,
+		),
+	)
+
+	authOpts = append(
+		authOpts,
+		lnurlauth.WithTrustedProxies(cfg.TrustedProxies...),
+		lnurlauth.WithChallengeTTL(cfg.Challenge.TTL),
+		lnurlauth.WithMaxChallenges(cfg.Challenge.Max),
+		lnurlauth.WithRateLimits(lnurlauth.RateLimits{
+			Callback:        lnurlauth.RateLimit(cfg.RateLimits.Callbacks),
+			FailedSignature: lnurlauth.RateLimit(cfg.RateLimits.FailedSignatures),
+			Challenge:       lnurlauth.RateLimit(cfg.RateLimits.Challenges),
+		}),
 	)
-	flag.Parse()
 
-	if *hostnamePtr == "" {
-		log.Fatal("--hostname flag is required")
+	if cfg.Challenge.Secret != "" {
+		authOpts = append(
+			authOpts,
+			lnurlauth.WithStatelessChallenges([]byte(cfg.Challenge.Secret)),
+		)
+	}
+
+	// Setup audit log.
+	if cfg.AuditLog.Path != "" {
+		auditSink, err := lnurlauth.NewFileAuditSink(
+			cfg.AuditLog.Path,
+			cfg.AuditLog.MaxSize*1024*1024,
+			cfg.AuditLog.MaxBackups,
+		)
+		if err != nil {
+			return fmt.Errorf("audit log: %w", err)
+		}
+		defer auditSink.Close()
+
+		authOpts = append(authOpts, lnurlauth.WithAuditSink(auditSink))
 	}
 
-	runServer(*hostnamePtr, *portPtr)
+	// Setup webhook notifications. Notifications left undelivered by the
+	// previous run are retried in the background, and closing the notifier
+	// stops the retry and waits for it.
+	if cfg.Webhook.URL != "" {
+		notifier, err := lnurlauth.NewWebhookNotifier(lnurlauth.WebhookConfig{
+			URL:            cfg.Webhook.URL,
+			Secret:         []byte(cfg.Webhook.Secret),
+			MaxAttempts:    cfg.Webhook.MaxAttempts,
+			DeadLetterPath: cfg.Webhook.DeadLetterFile,
+		})
+		if err != nil {
+			return fmt.Errorf("webhook: %w", err)
+		}
+		defer notifier.Close()
+
+		go func() {
+			if err := notifier.RetryDeadLetters(); err != nil {
+				log.Printf("webhook: %s", err.Error())
+			}
+		}()
+
+		authOpts = append(authOpts, lnurlauth.WithWebhook(notifier))
+	}
+
+	// Setup Prometheus metrics.
+	if cfg.Metrics.Path != "" {
+		authOpts = append(
+			authOpts,
+			lnurlauth.WithMetrics(prometheus.DefaultRegisterer),
+		)
+	}
+
+	// Setup bearer tokens for API clients if a signing key is given. A
+	// generated key would invalidate every token on restart.
+	if cfg.Tokens.enabled() {
+		tokenSigningKey, err := loadPrivateKey(cfg.Tokens.SigningKey)
+		if err != nil {
+			return fmt.Errorf("token signing key: %w", err)
+		}
+		authOpts = append(
+			authOpts,
+			lnurlauth.WithAccessTokens(tokenSigningKey, cfg.Tokens.TTL),
+		)
+	}
+
+	// Setup OpenID Connect provider if any client is registered. Without a
+	// signing key, the provider generates its own on every start.
+	var oidcOpts []oidc.Option
+	for _, client := range cfg.OIDC.Clients {
+		oidcOpts = append(oidcOpts, oidc.WithClient(client))
+	}
+	if len(oidcOpts) > 0 && cfg.OIDC.SigningKey != "" {
+		key, err := loadPrivateKey(cfg.OIDC.SigningKey)
+		if err != nil {
+			return fmt.Errorf("oidc signing key: %w", err)
+		}
+		signingKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return errors.New(
+				"oidc signing key: key must be an ECDSA private key",
+			)
+		}
+
+		oidcOpts = append(oidcOpts, oidc.WithSigningKey(signingKey))
+	}
+
+	return runServer(cfg, authOpts, oidcOpts)
 }
 
 // runServer initiates an HTTP server containing the demo application of
-// LNURL-auth authentication strategy. The `hostname` parameter is used to
-// further generate LNURL and the `port` parameter is the server port on which
-// you desire to run on.
-func runServer(hostname string, port int) {
+// LNURL-auth authentication strategy. The `cfg` parameter gives the hostname
+// used to further generate LNURL, the address on which you desire to run on,
+// the TLS settings and the path of the Prometheus metrics endpoint, and the
+// `authOpts` parameter configures the authentication service. The OpenID
+// Connect provider is enabled only if `oidcOpts` is not empty. It returns once
+// the server has been stopped by SIGINT or SIGTERM and the in-flight requests,
+// including the login event streams, have finished or the shutdown timeout has
+// passed. It returns an error if the server cannot be set up or stops serving
+// on its own.
+func runServer(
+	cfg config,
+	authOpts []lnurlauth.Option,
+	oidcOpts []oidc.Option,
+) error {
 	// Setup handler functions.
-	lnurlAuth := NewAuth(hostname)
-	handler := NewHandler(lnurlAuth)
+	lnurlAuth, err := lnurlauth.NewAuth(cfg.Hostname, authOpts...)
+	if err != nil {
+		return fmt.Errorf("lnurlauth: %w", err)
+	}
+	handler := lnurlauth.NewHandler(lnurlAuth)
 
 	// Setup HTML templates for the handlers to use.
 	tmpl := template.Must(template.New("").
@@ -47,8 +218,145 @@ func runServer(hostname string, port int) {
 	r.SetHTMLTemplate(tmpl)
 
 	r.GET("/", lnurlAuth.Middleware, handler.Home)
-	r.GET("/login", handler.Login)
+	r.GET("/login", lnurlAuth.LimitCallbacks, handler.Login)
+	r.GET("/login/events", lnurlAuth.Middleware, handler.LoginEvents)
+	r.GET("/login/ws", lnurlAuth.Middleware, handler.LoginWebSocket)
 	r.GET("/logout", lnurlAuth.Middleware, handler.Logout)
+	r.POST("/account/profile", lnurlAuth.Middleware, handler.UpdateProfile)
+	r.GET(
+		"/account/link",
+		lnurlAuth.LimitChallenges,
+		lnurlAuth.Middleware,
+		handler.LinkWallet,
+	)
+	r.POST("/account/unlink", lnurlAuth.Middleware, handler.UnlinkKey)
+	r.GET("/account/sessions", lnurlAuth.Middleware, handler.SessionsPage)
+
+	api := r.Group("/api")
+	api.POST(
+		"/challenge",
+		lnurlAuth.LimitChallenges,
+		lnurlAuth.Middleware,
+		handler.CreateChallenge,
+	)
+	api.GET("/challenge/:k1/status", handler.ChallengeStatus)
+	if cfg.Tokens.enabled() {
+		api.POST("/token", lnurlAuth.Middleware, handler.CreateToken)
+		api.POST("/token/refresh", handler.RefreshToken)
+	}
+	api.GET("/sessions", lnurlAuth.Middleware, handler.Sessions)
+	api.DELETE("/sessions/:id", lnurlAuth.Middleware, handler.RevokeSession)
+	api.DELETE("/sessions", lnurlAuth.Middleware, handler.LogoutEverywhere)
+
+	if len(oidcOpts) > 0 {
+		provider, err := oidc.NewProvider(
+			lnurlAuth,
+			cfg.Hostname,
+			append(
+				oidcOpts,
+				oidc.WithLoginTemplate(tmpl.Lookup("login.tmpl")),
+			)...,
+		)
+		if err != nil {
+			return fmt.Errorf("oidc: %w", err)
+		}
+
+		r.GET(oidc.DiscoveryPath, provider.Discovery)
+		r.GET(
+			oidc.AuthorizePath,
+			lnurlAuth.LimitChallenges,
+			lnurlAuth.Middleware,
+			provider.Authorize,
+		)
+		r.POST(oidc.TokenPath, provider.Token)
+		r.GET(oidc.UserInfoPath, provider.UserInfo)
+		r.POST(oidc.UserInfoPath, provider.UserInfo)
+		r.GET(oidc.JWKSPath, provider.JWKS)
+	}
+
+	if cfg.Metrics.Path != "" {
+		r.GET(cfg.Metrics.Path, gin.WrapH(promhttp.Handler()))
+	}
+
+	tlsConfig, err := loadTLSConfig(cfg.TLS, cfg.Hostname)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	server := &http.Server{
+		Addr:      cfg.Listen,
+		Handler:   r,
+		TLSConfig: tlsConfig,
+	}
+
+	// Serve until SIGINT or SIGTERM is received. A second signal terminates
+	// the server immediately.
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	serveErrs := make(chan error, 2)
+	go func() {
+		if tlsConfig == nil {
+			serveErrs <- server.ListenAndServe()
+			return
+		}
+		// The certificate is already in the TLS configuration.
+		serveErrs <- server.ListenAndServeTLS("", "")
+	}()
+
+	var redirectServer *http.Server
+	if tlsConfig != nil && cfg.TLS.RedirectListen != "" {
+		redirectServer = &http.Server{
+			Addr:    cfg.TLS.RedirectListen,
+			Handler: redirectToHTTPS(cfg.Hostname),
+		}
+		go func() {
+			serveErrs <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-serveErrs:
+		serveErr = fmt.Errorf("server: %w", serveErr)
+	case <-ctx.Done():
+	}
+	stop()
+	log.Print("server: shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(
+		context.Background(),
+		cfg.ShutdownTimeout,
+	)
+	defer cancel()
+
+	// The event streams are ended while the server drains its connections, as
+	// the server waits for the streams to finish.
+	streamsDone := make(chan error, 1)
+	go func() {
+		streamsDone <- lnurlAuth.Shutdown(shutdownCtx)
+	}()
+
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("tls redirect: %s", err.Error())
+		}
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("server: %s", err.Error())
+	}
+	if err := <-streamsDone; err != nil {
+		log.Printf("event streams: %s", err.Error())
+	}
+
+	return serveErr
+}
 
-	r.Run(fmt.Sprintf(":%d", port))
+// safeURL converts a URL of type `string` to the URL of type `template.URL` so
+// that the URL can be used on the HTML template.
+func safeURL(url string) template.URL {
+	return template.URL(url)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/sunboyy/lnurlauth/pkg/oidc"
+)
+
+// oidcClientList is a list of OpenID Connect clients, each given in the
+// format <client_id>:<client_secret>:<redirect_uri>. It is not a flag since
+// the clients contain their secrets.
+type oidcClientList []oidc.Client
+
+// add parses a client from the value and appends to the list. The same
+// client ID can be given multiple times to register multiple redirect URIs.
+func (l *oidcClientList) add(value string) error {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return errors.New(
+			"client must be <client_id>:<client_secret>:<redirect_uri>",
+		)
+	}
+
+	for i, client := range *l {
+		if client.ID == parts[0] {
+			if client.Secret != parts[1] {
+				return errors.New("client secret does not match")
+			}
+			(*l)[i].RedirectURIs = append(client.RedirectURIs, parts[2])
+			return nil
+		}
+	}
+
+	*l = append(*l, oidc.Client{
+		ID:           parts[0],
+		Secret:       parts[1],
+		RedirectURIs: []string{parts[2]},
+	})
+	return nil
+}
+
+// UnmarshalYAML parses the clients from a YAML list of strings in the client
+// format.
+func (l *oidcClientList) UnmarshalYAML(
+	unmarshal func(interface{}) error,
+) error {
+	var values []string
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		if err := l.add(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
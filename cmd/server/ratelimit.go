@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+)
+
+// rateLimitFlag is a flag of a rate limit in the format <count>/<period>, e.g.
+// `30/1m`. A count of zero disables the rate limit.
+type rateLimitFlag lnurlauth.RateLimit
+
+// String formats the rate limit in the flag format.
+func (f *rateLimitFlag) String() string {
+	return strconv.Itoa(f.Limit) + "/" + f.Period.String()
+}
+
+// Set parses the rate limit from the flag value.
+func (f *rateLimitFlag) Set(value string) error {
+	countStr, periodStr, ok := strings.Cut(value, "/")
+	if !ok {
+		return errors.New("rate limit must be <count>/<period>")
+	}
+
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 0 {
+		return errors.New("count must be a non-negative integer")
+	}
+
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return errors.New("period must be a positive duration")
+	}
+
+	f.Limit = count
+	f.Period = period
+	return nil
+}
+
+// UnmarshalYAML parses the rate limit from a YAML string in the flag format.
+func (f *rateLimitFlag) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return f.Set(value)
+}
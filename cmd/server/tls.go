@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is the validity period of the self-signed certificate.
+const selfSignedValidity = time.Hour * 24 * 365
+
+// loadTLSConfig returns the TLS configuration of the server, which is nil if
+// TLS is disabled. The certificate is either loaded from the files or, for
+// development, generated for the host of the hostname.
+func loadTLSConfig(c tlsConfig, hostname string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case c.SelfSigned:
+		cert, err = selfSignedCertificate(hostname)
+	case c.CertFile != "":
+		cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// selfSignedCertificate generates a self-signed certificate valid for the host
+// of the hostname as well as for localhost. The certificate lives in memory
+// only, so a new one is generated on every start. Its SHA-256 fingerprint is
+// logged so that it can be compared with the one shown by the wallet.
+func selfSignedCertificate(hostname string) (tls.Certificate, error) {
+	u, err := url.Parse(hostname)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	host := u.Hostname()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serialNumber, err := rand.Int(
+		rand.Reader,
+		new(big.Int).Lsh(big.NewInt(1), 128),
+	)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(
+		rand.Reader,
+		template,
+		template,
+		&key.PublicKey,
+		key,
+	)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	fingerprint := sha256.Sum256(der)
+	log.Printf(
+		"tls: generated self-signed certificate for %s (SHA-256 %s)",
+		host,
+		strings.ToUpper(hex.EncodeToString(fingerprint[:])),
+	)
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// redirectToHTTPS is a handler redirecting every request to the same path
+// under the HTTPS hostname. The hostname is used instead of the Host header
+// of the request, so the redirect cannot be pointed at another site. The
+// method and the body are preserved, so form posts keep working.
+func redirectToHTTPS(hostname string) http.Handler {
+	base := strings.TrimSuffix(hostname, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(
+			w,
+			r,
+			base+r.URL.RequestURI(),
+			http.StatusPermanentRedirect,
+		)
+	})
+}
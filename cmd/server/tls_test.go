@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedCertificateCoversHostname(t *testing.T) {
+	for _, test := range []struct {
+		hostname string
+		dnsName  string
+		ip       net.IP
+	}{
+		{
+			hostname: "https://auth.example.com",
+			dnsName:  "auth.example.com",
+		},
+		{
+			hostname: "https://192.168.1.10:8443",
+			ip:       net.ParseIP("192.168.1.10"),
+		},
+		{
+			hostname: "https://localhost:8443",
+		},
+	} {
+		serverConfig, err := loadTLSConfig(
+			tlsConfig{SelfSigned: true},
+			test.hostname,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serverConfig.MinVersion != tls.VersionTLS12 ||
+			len(serverConfig.Certificates) != 1 {
+			t.Fatalf("unexpected tls config %+v", serverConfig)
+		}
+
+		cert, err := x509.ParseCertificate(
+			serverConfig.Certificates[0].Certificate[0],
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The certificate is valid for the host of the hostname and for
+		// localhost, and only for serving.
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if test.dnsName != "" {
+			hosts = append(hosts, test.dnsName)
+		}
+		if test.ip != nil {
+			hosts = append(hosts, test.ip.String())
+		}
+		for _, host := range hosts {
+			if err := cert.VerifyHostname(host); err != nil {
+				t.Errorf("%s: %s", test.hostname, err)
+			}
+		}
+		if err := cert.VerifyHostname("other.example.com"); err == nil {
+			t.Errorf("%s: expected other hosts to be rejected", test.hostname)
+		}
+		if len(cert.ExtKeyUsage) != 1 ||
+			cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+			t.Errorf(
+				"%s: unexpected key usage %v",
+				test.hostname,
+				cert.ExtKeyUsage,
+			)
+		}
+		if now := time.Now(); now.Before(cert.NotBefore) ||
+			now.Add(selfSignedValidity-time.Hour).After(cert.NotAfter) {
+			t.Errorf(
+				"%s: unexpected validity %s - %s",
+				test.hostname,
+				cert.NotBefore,
+				cert.NotAfter,
+			)
+		}
+
+		// The certificate is self-signed.
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		if _, err := cert.Verify(x509.VerifyOptions{
+			DNSName: "localhost",
+			Roots:   roots,
+		}); err != nil {
+			t.Errorf("%s: %s", test.hostname, err)
+		}
+	}
+}
+
+func TestLoadTLSConfigIsNilWithoutTLS(t *testing.T) {
+	serverConfig, err := loadTLSConfig(tlsConfig{}, "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverConfig != nil {
+		t.Fatalf("expected no tls config, got %+v", serverConfig)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	handler := redirectToHTTPS("https://auth.example.com/")
+
+	// The Host header of the request is ignored, and the method and the body
+	// are kept by the permanent redirect.
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"http://evil.example.com/account/profile?next=%2F",
+		nil,
+	)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPermanentRedirect {
+		t.Fatalf("expected permanent redirect, got %d", rec.Code)
+	}
+	location := rec.Header().Get("Location")
+	if location != "https://auth.example.com/account/profile?next=%2F" {
+		t.Fatalf("unexpected location %q", location)
+	}
+}
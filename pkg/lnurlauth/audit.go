@@ -0,0 +1,125 @@
+package lnurlauth
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// AuditEventType is the type of an audit event.
+type AuditEventType string
+
+const (
+	// AuditEventChallengeIssued is recorded when a new k1 challenge is
+	// issued to a session.
+	AuditEventChallengeIssued AuditEventType = "challenge_issued"
+
+	// AuditEventLoginSucceeded is recorded when a wallet application has
+	// signed a k1 challenge and the action of the challenge is performed.
+	AuditEventLoginSucceeded AuditEventType = "login_succeeded"
+
+	// AuditEventLoginFailed is recorded when a login callback is rejected.
+	// The reason is the LUD-04 error reason sent to the wallet application.
+	AuditEventLoginFailed AuditEventType = "login_failed"
+
+	// AuditEventLogout is recorded when a signed-in session is logged out,
+	// including revoked sessions.
+	AuditEventLogout AuditEventType = "logout"
+
+	// AuditEventSessionExpired is recorded when a signed-in session is
+	// removed from the store because it has expired. It is only recorded if
+	// the store implements SessionExpiryNotifier.
+	AuditEventSessionExpired AuditEventType = "session_expired"
+)
+
+// AuditEvent is a structured record of an authentication event. Fields that
+// do not apply to the event are empty.
+type AuditEvent struct {
+	// Type is the type of the event.
+	Type AuditEventType `json:"type"`
+
+	// Time is the time at which the event happened.
+	Time time.Time `json:"time"`
+
+	// SessionHash identifies the session without revealing the session ID.
+	// It is the same as the ID in SessionInfo. Since the session ID is
+	// replaced when an anonymous browser requests a challenge, the events
+	// before and after that request have different hashes.
+	SessionHash string `json:"sessionHash,omitempty"`
+
+	// AccountID is the ID of the account that the session is signed in to.
+	AccountID string `json:"accountId,omitempty"`
+
+	// LinkingKey is the linking key given by the wallet application. For
+	// logouts and expired sessions, it is the linking key that the session
+	// signed in with.
+	LinkingKey string `json:"linkingKey,omitempty"`
+
+	// Action is the LUD-04 action of the k1 challenge.
+	Action Action `json:"action,omitempty"`
+
+	// Reason is the reason of a failed login.
+	Reason string `json:"reason,omitempty"`
+
+	// IP is the IP address of the client. For logouts and expired sessions,
+	// it is the IP address of the latest request of the session.
+	IP string `json:"ip,omitempty"`
+
+	// UserAgent is the user agent of the client. For logouts and expired
+	// sessions, it is the user agent of the latest request of the session.
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// AuditSink receives the audit events of Auth. Events are delivered
+// synchronously, so Audit should return quickly, and it must be safe for
+// concurrent use. A failure to record an event is logged and does not fail
+// the authentication.
+type AuditSink interface {
+	// Audit records the event.
+	Audit(event AuditEvent) error
+}
+
+// clientInfo describes the HTTP client making a request to the
+// authentication service. It is empty when Auth is called without a request.
+type clientInfo struct {
+	ip        string
+	userAgent string
+}
+
+// newClientInfo returns the information of the client making the request.
+func (a *Auth) newClientInfo(r *http.Request) clientInfo {
+	return clientInfo{
+		ip:        a.clientIP(r),
+		userAgent: r.UserAgent(),
+	}
+}
+
+// audit sends the event to the audit sink if one is configured. The time of
+// the event is set to now unless it is already set.
+func (a *Auth) audit(event AuditEvent) {
+	if a.auditSink == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if err := a.auditSink.Audit(event); err != nil {
+		log.Printf("audit: %s", err.Error())
+	}
+}
+
+// auditSessionExpired records the expiry of the session. It is registered to
+// the store if the store implements SessionExpiryNotifier.
+func (a *Auth) auditSessionExpired(session Session) {
+	a.audit(AuditEvent{
+		Type:        AuditEventSessionExpired,
+		Time:        session.ExpiresAt,
+		SessionHash: publicSessionID(session.ID),
+		AccountID:   session.AccountID,
+		LinkingKey:  session.LinkingKey,
+		IP:          session.IP,
+		UserAgent:   session.UserAgent,
+	})
+}
@@ -0,0 +1,177 @@
+package lnurlauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileAuditSink is an implementation of AuditSink writing the audit events to
+// a file in the JSON Lines format, one event per line. The file is rotated
+// once it would grow beyond the maximum size: the current file is renamed
+// with the suffix `.1`, the previous `.1` to `.2` and so on, and the oldest
+// file beyond the maximum number of backups is removed.
+type FileAuditSink struct {
+	// path is the location of the current file.
+	path string
+
+	// maxSize is the maximum size of a file in bytes.
+	maxSize int64
+
+	// maxBackups is the number of rotated files to keep.
+	maxBackups int
+
+	// mu guards file, size and closed.
+	mu   sync.Mutex
+	file *os.File
+
+	// size is the current size of the file.
+	size int64
+
+	// closed reports whether the sink has been closed.
+	closed bool
+}
+
+// NewFileAuditSink is a constructor of FileAuditSink. The events are appended
+// to the file at the given path, which is created if it does not exist.
+func NewFileAuditSink(
+	path string,
+	maxSize int64,
+	maxBackups int,
+) (*FileAuditSink, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("max size of audit log must be positive")
+	}
+	if maxBackups < 0 {
+		return nil, errors.New("max backups of audit log must not be negative")
+	}
+
+	s := &FileAuditSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Audit appends the event to the file, rotating the file first if the event
+// does not fit.
+func (s *FileAuditSink) Audit(event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("audit log is closed")
+	}
+
+	// The file is missing if it could not be reopened after a rotation.
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the file. Events audited afterwards are rejected.
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// open opens the current file for appending. The caller must hold the lock
+// unless the sink is being constructed.
+func (s *FileAuditSink) open() error {
+	file, err := os.OpenFile(
+		s.path,
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+		0o600,
+	)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups, moves the current file to the first backup and
+// opens a new current file. If the files cannot be moved, the current file is
+// reopened so that the following events are still appended to it, and the
+// error is returned. The caller must hold the lock.
+func (s *FileAuditSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err == nil {
+		err = s.shiftFiles()
+	}
+
+	if openErr := s.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+// shiftFiles renames each backup to the next one and the current file to the
+// first backup. The oldest backup, or the current file if no backup is kept,
+// is overwritten or removed.
+func (s *FileAuditSink) shiftFiles() error {
+	if s.maxBackups == 0 {
+		return os.Remove(s.path)
+	}
+
+	for i := s.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(s.backupPath(i), s.backupPath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return os.Rename(s.path, s.backupPath(1))
+}
+
+// backupPath returns the path of the i-th most recent backup.
+func (s *FileAuditSink) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
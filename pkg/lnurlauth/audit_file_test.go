@@ -0,0 +1,110 @@
+package lnurlauth_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+)
+
+func TestFileAuditSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := lnurlauth.NewFileAuditSink(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	// Each event fits in the file but two of them do not.
+	for i := 0; i < 4; i++ {
+		if err := sink.Audit(lnurlauth.AuditEvent{
+			Type:   lnurlauth.AuditEventLoginFailed,
+			Time:   time.Unix(int64(i), 0).UTC(),
+			Reason: strings.Repeat("x", 100),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The oldest event has been dropped with the third backup.
+	for i, name := range []string{path, path + ".1", path + ".2"} {
+		dat, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var event lnurlauth.AuditEvent
+		if err := json.Unmarshal(dat, &event); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if event.Time.Unix() != int64(3-i) {
+			t.Fatalf("%s: expected event %d, got %+v", name, 3-i, event)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no third backup, got %v", err)
+	}
+}
+
+func TestFileAuditSinkKeepsWritingAfterFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := lnurlauth.NewFileAuditSink(path, 200, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	audit := func(i int) error {
+		return sink.Audit(lnurlauth.AuditEvent{
+			Type:   lnurlauth.AuditEventLoginFailed,
+			Time:   time.Unix(int64(i), 0).UTC(),
+			Reason: strings.Repeat("x", 100),
+		})
+	}
+	if err := audit(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A non-empty directory in place of the backup cannot be replaced.
+	blocked := filepath.Join(path+".1", "blocked")
+	if err := os.MkdirAll(blocked, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit(1); err == nil {
+		t.Fatal("expected failed rotation to be reported")
+	}
+
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit(2); err != nil {
+		t.Fatalf("expected the sink to recover, got %v", err)
+	}
+
+	for i, name := range []string{path, path + ".1"} {
+		dat, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var event lnurlauth.AuditEvent
+		if err := json.Unmarshal(dat, &event); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if event.Time.Unix() != int64(2-2*i) {
+			t.Fatalf("%s: expected event %d, got %+v", name, 2-2*i, event)
+		}
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit(3); err == nil {
+		t.Fatal("expected closed sink to reject events")
+	}
+}
@@ -0,0 +1,91 @@
+package lnurlauth_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestAuditLogRecordsLoginCycle(t *testing.T) {
+	sink := &auditRecorder{}
+	store := lnurlauth.NewMemoryStore()
+	server := newHTTPServer(
+		t,
+		lnurlauth.WithStore(store),
+		lnurlauth.WithAuditSink(sink),
+	)
+	browser := newBrowser()
+
+	k1 := createChallenge(t, browser, server.URL)
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Login(server.URL, strings.Repeat("ab", 32)); err == nil {
+		t.Fatal("expected login with unknown challenge to fail")
+	}
+	if err := w.Login(server.URL, k1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+
+	res, err := browser.Get(server.URL + "/logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	events := sink.recorded()
+	expected := []lnurlauth.AuditEventType{
+		lnurlauth.AuditEventChallengeIssued,
+		lnurlauth.AuditEventLoginFailed,
+		lnurlauth.AuditEventLoginSucceeded,
+		lnurlauth.AuditEventLogout,
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i] {
+			t.Fatalf("expected event %d to be %s, got %+v", i, expected[i], event)
+		}
+		if event.Time.IsZero() || event.IP == "" || event.UserAgent == "" {
+			t.Fatalf("expected time and client of event %d, got %+v", i, event)
+		}
+	}
+
+	if events[1].Reason != "unknown k1 challenge" ||
+		events[1].LinkingKey != w.LinkingKey() {
+		t.Fatalf("unexpected failed login %+v", events[1])
+	}
+	accountID := walletAccountID(t, store, w)
+	if events[2].AccountID != accountID ||
+		events[2].LinkingKey != w.LinkingKey() ||
+		events[2].SessionHash != events[0].SessionHash {
+		t.Fatalf("unexpected successful login %+v", events[2])
+	}
+	if events[3].AccountID != accountID || events[3].SessionHash == "" {
+		t.Fatalf("unexpected logout %+v", events[3])
+	}
+}
+
+// auditRecorder is an audit sink keeping the events in memory.
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []lnurlauth.AuditEvent
+}
+
+func (r *auditRecorder) Audit(event lnurlauth.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *auditRecorder) recorded() []lnurlauth.AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]lnurlauth.AuditEvent(nil), r.events...)
+}
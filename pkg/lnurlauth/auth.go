@@ -0,0 +1,1097 @@
+// Package lnurlauth implements the server side of LNURL-auth authentication
+// strategy (https://github.com/fiatjaf/lnurl-rfc/blob/luds/04.md) for Gin web
+// applications.
+package lnurlauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"github.com/sunboyy/lnurlauth/pkg"
+)
+
+const (
+	sessionKey        = "lnurl_sess"
+	lnurlAuthEndpoint = "/login"
+)
+
+const (
+	defaultChallengeTTL    = time.Minute * 5
+	defaultIdleTimeout     = time.Hour
+	defaultAbsoluteTimeout = time.Hour * 24
+)
+
+var (
+	// errChallengeExpired is returned when the k1 challenge was issued but
+	// is no longer usable.
+	errChallengeExpired = errors.New("k1 challenge has expired")
+
+	// errChallengeUsed is returned when the k1 challenge has already been
+	// used to login.
+	errChallengeUsed = errors.New("k1 challenge has already been used")
+
+	// errChallengeUnknown is returned when the k1 challenge was not issued
+	// by the server.
+	errChallengeUnknown = errors.New("unknown k1 challenge")
+
+	// errInvalidSignature is returned when the signature of the k1 challenge
+	// cannot be verified with the linking key.
+	errInvalidSignature = errors.New("invalid signature")
+
+	// errKeyRegistered is returned when a register action is performed with
+	// a linking key that already belongs to an account.
+	errKeyRegistered = errors.New("linking key is already registered")
+
+	// errKeyNotRegistered is returned when a login action is performed with
+	// a linking key that does not belong to any account.
+	errKeyNotRegistered = errors.New("linking key is not registered")
+
+	// errKeyOfAnotherAccount is returned when a link action is performed with
+	// a linking key that belongs to another account.
+	errKeyOfAnotherAccount = errors.New(
+		"linking key belongs to another account",
+	)
+
+	// errNotSignedIn is returned when an operation requires a signed-in
+	// session.
+	errNotSignedIn = errors.New("session is not signed in")
+)
+
+const (
+	// SessionIDContextKey is the key of the session ID set to the request
+	// context by Auth.Middleware.
+	SessionIDContextKey = "session_id"
+
+	// AccountIDContextKey is the key of the ID of the signed-in account set to
+	// the request context by Auth.Middleware if the user is signed in.
+	AccountIDContextKey = "account_id"
+)
+
+// Auth is an authentication service for the server. It utilizes digital
+// signature algorithm to authenticate the user to the system. To authenticate
+// a user, the system generates a random data for the user to sign. The user
+// provides the public key identifying himself and a signature according to the
+// random data that the server generates. Valid signatures indicate that the
+// user is authentic and is allowed to use the system. Auth utilizes LNURL-auth
+// standard (https://github.com/fiatjaf/lnurl-rfc/blob/luds/04.md) so that it
+// is compatible with Bitcoin Lightning Wallet application.
+type Auth struct {
+	// hostname is the host name of the server that the client will call. It is
+	// used for generating LNURL for the Bitcoin Lightning wallet application.
+	hostname string
+
+	// store is a storage of the outstanding k1 challenges, the accounts and
+	// the mappings between session ID and account ID.
+	store Store
+
+	// accountMu serializes the updates of the accounts, which read the
+	// account from the store, modify it and save it back, so that concurrent
+	// updates, e.g. linking and unlinking keys, do not overwrite each other.
+	accountMu sync.Mutex
+
+	// stateless issues and validates k1 challenges without storing them in
+	// the store. If it is nil, k1 challenges are kept in the store.
+	stateless *statelessChallenger
+
+	// challengeTTL is the duration in which a k1 challenge can be used after
+	// issued.
+	challengeTTL time.Duration
+
+	// challenges tracks the outstanding k1 challenges issued by this server
+	// to bound their number.
+	challenges *challengeLRU
+
+	// limits are the rate limits of the requests.
+	limits RateLimits
+
+	// callbackLimiter limits the login callbacks per IP address.
+	callbackLimiter *rateLimiter
+
+	// failedSignatureLimiter limits the failed signature verifications per k1
+	// challenge.
+	failedSignatureLimiter *rateLimiter
+
+	// challengeLimiter limits the requests creating k1 challenges per IP
+	// address.
+	challengeLimiter *rateLimiter
+
+	// events delivers authentication events to the subscribers of each
+	// session.
+	events *EventBroker
+
+	// tokens issues and verifies bearer tokens. If it is nil, bearer tokens
+	// are disabled.
+	tokens *tokenIssuer
+
+	// cookie contains the attributes of the session cookie.
+	cookie CookieConfig
+
+	// proxies are the reverse proxies whose forwarding headers are trusted
+	// to find the IP address of the client.
+	proxies trustedProxies
+
+	// idleTimeout is the duration of inactivity after which a session is
+	// signed out.
+	idleTimeout time.Duration
+
+	// absoluteTimeout is the duration after signing in after which a session
+	// is signed out regardless of activity.
+	absoluteTimeout time.Duration
+
+	// auditSink receives the audit events. If it is nil, nothing is audited.
+	auditSink AuditSink
+
+	// metrics are the Prometheus metrics. If it is nil, nothing is measured.
+	metrics *metrics
+
+	// webhooks deliver the login and logout notifications.
+	webhooks []*WebhookNotifier
+}
+
+// issuedChallenge is a k1 challenge issued for a session.
+type issuedChallenge struct {
+	// k1 is the k1 challenge in a hexadecimal string format.
+	k1 string
+
+	// state is the challenge state which must be sent back together with the
+	// k1 challenge in stateless mode. It is empty otherwise.
+	state string
+
+	// action is the action of the challenge.
+	action Action
+
+	// expiresAt is the time after which the challenge can no longer be used.
+	expiresAt time.Time
+}
+
+// NewAuth is a constructor of Auth. The `hostname` parameter is the base URL
+// of the server (e.g. https://example.com) on which the login handler is
+// served at `/login`.
+func NewAuth(hostname string, opts ...Option) (*Auth, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	a := &Auth{
+		hostname:        hostname,
+		store:           o.store,
+		challengeTTL:    o.challengeTTL,
+		events:          NewEventBroker(),
+		idleTimeout:     o.idleTimeout,
+		absoluteTimeout: o.absoluteTimeout,
+		auditSink:       o.auditSink,
+		webhooks:        o.webhooks,
+	}
+
+	if a.store == nil {
+		a.store = NewMemoryStore()
+	}
+
+	// Audit the sessions that the store removes because they have expired.
+	if notifier, ok := a.store.(SessionExpiryNotifier); ok &&
+		a.auditSink != nil {
+		notifier.OnSessionExpired(a.auditSessionExpired)
+	}
+
+	cookie := defaultCookieConfig(hostname)
+	if o.cookie != nil {
+		cookie = *o.cookie
+	}
+	cookie, err := cookie.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	a.cookie = cookie
+
+	if a.proxies, err = newTrustedProxies(o.trustedProxies); err != nil {
+		return nil, err
+	}
+
+	a.limits = defaultRateLimits
+	if o.rateLimits != nil {
+		a.limits = *o.rateLimits
+	}
+	if a.callbackLimiter, err = newRateLimiter(
+		a.limits.Callback,
+	); err != nil {
+		return nil, err
+	}
+	if a.failedSignatureLimiter, err = newRateLimiter(
+		a.limits.FailedSignature,
+	); err != nil {
+		return nil, err
+	}
+	if a.challengeLimiter, err = newRateLimiter(
+		a.limits.Challenge,
+	); err != nil {
+		return nil, err
+	}
+
+	if a.challengeTTL == 0 {
+		a.challengeTTL = defaultChallengeTTL
+	}
+	if a.challengeTTL < 0 {
+		return nil, errors.New("challenge ttl must be positive")
+	}
+
+	if a.idleTimeout == 0 {
+		a.idleTimeout = defaultIdleTimeout
+	}
+	if a.absoluteTimeout == 0 {
+		a.absoluteTimeout = defaultAbsoluteTimeout
+	}
+	if a.idleTimeout < 0 || a.absoluteTimeout < 0 {
+		return nil, errors.New("session timeouts must be positive")
+	}
+	if a.absoluteTimeout < a.idleTimeout {
+		return nil, errors.New(
+			"absolute timeout must not be shorter than idle timeout",
+		)
+	}
+
+	if o.challengeSecret != nil {
+		stateless, err := newStatelessChallenger(
+			o.challengeSecret,
+			a.challengeTTL,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		a.stateless = stateless
+	}
+
+	maxChallenges := o.maxChallenges
+	if maxChallenges == 0 {
+		maxChallenges = defaultMaxChallenges
+	}
+	if maxChallenges < 0 {
+		return nil, errors.New("max challenges must be positive")
+	}
+	a.challenges = newChallengeLRU(maxChallenges)
+
+	if o.metricsRegisterer != nil {
+		if a.metrics, err = newMetrics(o.metricsRegisterer, a); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.tokenKey != nil {
+		tokens, err := newTokenIssuer(
+			o.tokenKey,
+			o.tokenTTL,
+			a.absoluteTimeout,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		a.tokens = tokens
+	}
+
+	return a, nil
+}
+
+// Middleware is an authentication middleware based on LNURL-auth strategy. It
+// tries to retrieve session ID cookie from the request, finds the account ID
+// related to the session ID and sets the account ID to the request context
+// with key `account_id`. If session ID cookie does not exist in the request,
+// it will generate a new session ID for the user. The session ID and the
+// account ID are also set to the context of the request so that net/http
+// handlers can read them. If bearer tokens are enabled, a request with an
+// access token in the `Authorization` header is authenticated with the token
+// instead of the cookie, and is rejected if the token is invalid.
+func (a *Auth) Middleware(c *gin.Context) {
+	// Authenticate with the bearer token if the request has one.
+	sessionID, accountID, ok, err := a.sessionFromBearerToken(c.Request)
+	if ok {
+		if err != nil {
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				gin.H{"error": "invalid access token"},
+			)
+			return
+		}
+
+		c.Set(SessionIDContextKey, sessionID)
+		c.Set(AccountIDContextKey, accountID)
+		c.Request = c.Request.WithContext(newSessionContext(
+			c.Request.Context(),
+			sessionID,
+			accountID,
+		))
+		c.Next()
+		return
+	}
+
+	// Always continue to the next middleware.
+	defer c.Next()
+
+	// Pass the session ID and the account ID to net/http handlers.
+	defer func() {
+		c.Request = c.Request.WithContext(newSessionContext(
+			c.Request.Context(),
+			c.GetString(SessionIDContextKey),
+			c.GetString(AccountIDContextKey),
+		))
+	}()
+
+	// Get session ID from the cookie.
+	sessionID, ok = a.sessionCookie(c.Request)
+
+	// If the request doesn't include session ID cookie, create and set a new
+	// session ID.
+	if !ok {
+		sessionID = random32BytesHex()
+		c.Set(SessionIDContextKey, sessionID)
+		a.setSessionCookie(c.Writer, sessionID)
+		return
+	}
+
+	c.Set(SessionIDContextKey, sessionID)
+
+	// Try to retrieve account ID.
+	accountID, ok = a.authenticateSession(c.Request, sessionID)
+
+	if ok {
+		// If the user is signed in, set the account ID to the request context.
+		c.Set(AccountIDContextKey, accountID)
+	}
+}
+
+// Challenge returns LNURL for the Lightning wallet application. It generates
+// a k1 challenge (a random data for the wallet application to sign), creates a
+// mapping with the session ID by setting into the challenge store and then
+// returns the LNURL that embeds the k1 challenge. A QR code image for the LNURL
+// is also provided for convenience. The action states the purpose of the
+// challenge and is enforced when the user logs in. Use ActionNone to leave the
+// action unspecified. The session ID of a session that is not signed in must
+// not be known to anyone but the browser, otherwise whoever knows it is signed
+// in together with the user. Handlers serving browsers should therefore use
+// RequestChallenge, which issues a new session ID with the challenge.
+func (a *Auth) Challenge(
+	sessionID string,
+	action Action,
+) (AuthChallenge, error) {
+	return a.challenge(sessionID, action, clientInfo{})
+}
+
+// RequestChallenge returns a k1 challenge with the action for the session of
+// the request, which must have passed through the authentication middleware.
+// If the session is not signed in, the challenge is issued for a new session
+// ID, which is set to the session cookie of the response. A session ID known
+// before the challenge was requested, e.g. one planted in the browser by an
+// attacker, is therefore never signed in by the login. The login events are
+// published to the new session ID, so the login page must subscribe to them
+// after receiving the challenge.
+func (a *Auth) RequestChallenge(
+	w http.ResponseWriter,
+	r *http.Request,
+	action Action,
+) (AuthChallenge, error) {
+	sessionID, ok := SessionIDFromContext(r.Context())
+	if !ok {
+		return AuthChallenge{}, errors.New(
+			"session ID is missing from the request context",
+		)
+	}
+	if !action.Valid() {
+		return AuthChallenge{}, fmt.Errorf("invalid action '%s'", action)
+	}
+
+	// A session ID that the middleware has just created for a request
+	// without the cookie is new already.
+	if _, ok := a.AccountID(sessionID); !ok {
+		if cookie, ok := a.sessionCookie(r); ok && cookie == sessionID {
+			sessionID = random32BytesHex()
+			a.setSessionCookie(w, sessionID)
+		}
+	}
+
+	return a.challenge(sessionID, action, a.newClientInfo(r))
+}
+
+// challenge is Challenge with the information of the client requesting the
+// challenge, which is recorded in the audit log.
+func (a *Auth) challenge(
+	sessionID string,
+	action Action,
+	client clientInfo,
+) (AuthChallenge, error) {
+	if !action.Valid() {
+		return AuthChallenge{}, fmt.Errorf("invalid action '%s'", action)
+	}
+
+	// Finds or creates k1 challenge.
+	challenge, err := a.issueChallenge(sessionID, action, client)
+	if err != nil {
+		return AuthChallenge{}, err
+	}
+
+	// Construct a login URL for the Lightning wallet application to call. This
+	// includes previously generated k1 challenge, the action if specified and,
+	// in stateless mode, the challenge state which the wallet application
+	// sends back unchanged.
+	query := url.Values{}
+	query.Set("tag", "login")
+	query.Set("k1", challenge.k1)
+	if challenge.action != ActionNone {
+		query.Set("action", string(challenge.action))
+	}
+	if challenge.state != "" {
+		query.Set("state", challenge.state)
+	}
+	actualURL := a.hostname + lnurlAuthEndpoint + "?" + query.Encode()
+
+	// Encode the login URL in bech32 format for the Lightning wallet
+	// application.
+	lnurl, err := lnurl.LNURLEncode(actualURL)
+	if err != nil {
+		return AuthChallenge{}, err
+	}
+
+	// Append bech32-encoded URL with lightning protocol so that the operating
+	// system can redirect the link to an appropriate app.
+	lnurl = pkg.LNURLProtocolPrefix + lnurl
+
+	// Generate a QR code image for the encoded LNURL
+	qrcodePNG, err := qrcode.Encode(lnurl, qrcode.Medium, 256)
+	if err != nil {
+		return AuthChallenge{}, err
+	}
+
+	return AuthChallenge{
+		LNURL: lnurl,
+		QRCodeURL: "data:image/png;base64," +
+			base64.StdEncoding.EncodeToString(qrcodePNG),
+		K1:        challenge.k1,
+		ExpiresAt: challenge.expiresAt,
+		Action:    challenge.action,
+	}, nil
+}
+
+// ChallengeStatus returns the status of the k1 challenge. A challenge is
+// pending until its signature is verified. If the challenge is unknown, e.g.
+// because its status has been dropped some time after it expired, it will
+// return false in the second return value.
+func (a *Auth) ChallengeStatus(k1 string) (ChallengeStatus, bool) {
+	return a.store.ChallengeStatus(k1)
+}
+
+// issueChallenge returns a k1 challenge with the action for the session. In
+// stateless mode, a new k1 challenge is issued together with its state.
+// Otherwise, the k1 challenge is taken from the store and the state is empty.
+func (a *Auth) issueChallenge(
+	sessionID string,
+	action Action,
+	client clientInfo,
+) (issuedChallenge, error) {
+	if a.stateless != nil {
+		challenge, err := a.stateless.issue(sessionID, action)
+		if err != nil {
+			return issuedChallenge{}, err
+		}
+
+		if err := a.challengeIssued(
+			sessionID,
+			challenge,
+			client,
+		); err != nil {
+			return issuedChallenge{}, err
+		}
+		return challenge, nil
+	}
+
+	return a.k1BySessionID(sessionID, action, client)
+}
+
+// challengeIssued records the newly issued k1 challenge as pending and
+// notifies the subscribers of the session that the challenge has been issued.
+// When the challenge expires without being verified, it is recorded as expired
+// and the subscribers are notified if the session is still not signed in, so
+// that the login page can show a new challenge. The status of the challenge is
+// kept for another TTL after it expires or is verified, so that late callbacks
+// and status queries can be told why the challenge is no longer usable. The
+// challenge is tracked so that the least recently used challenges are evicted
+// once there are too many of them, as each of them holds a timer and a status
+// until it expires.
+func (a *Auth) challengeIssued(
+	sessionID string,
+	challenge issuedChallenge,
+	client clientInfo,
+) error {
+	if err := a.store.SetChallengeStatus(
+		challenge.k1,
+		ChallengeStatusPending,
+		time.Until(challenge.expiresAt),
+	); err != nil {
+		return err
+	}
+
+	a.events.Publish(sessionID, AuthEvent{Type: AuthEventIssued})
+	a.metrics.challengeIssued(challenge.action)
+	a.audit(AuditEvent{
+		Type:        AuditEventChallengeIssued,
+		SessionHash: publicSessionID(sessionID),
+		Action:      challenge.action,
+		IP:          client.ip,
+		UserAgent:   client.userAgent,
+	})
+
+	timer := time.AfterFunc(time.Until(challenge.expiresAt), func() {
+		a.challenges.remove(challenge.k1)
+
+		status, _ := a.store.ChallengeStatus(challenge.k1)
+		if status == ChallengeStatusVerified {
+			return
+		}
+
+		if err := a.store.SetChallengeStatus(
+			challenge.k1,
+			ChallengeStatusExpired,
+			a.challengeTTL,
+		); err != nil {
+			log.Printf("challenge status: %s", err.Error())
+		}
+
+		if _, ok := a.AccountID(sessionID); !ok {
+			a.events.Publish(sessionID, AuthEvent{Type: AuthEventExpired})
+		}
+	})
+
+	evicted := a.challenges.add(trackedChallenge{
+		k1:        challenge.k1,
+		sessionID: sessionID,
+		issuedAt:  challenge.expiresAt.Add(-a.challengeTTL),
+		expiresAt: challenge.expiresAt,
+		timer:     timer,
+	})
+	for _, challenge := range evicted {
+		a.evictChallenge(challenge)
+	}
+
+	return nil
+}
+
+// evictChallenge removes the evicted k1 challenge from the store before it
+// expires. It is reported as expired, and the subscribers of the session are
+// notified so that the login page can request a new challenge. A stateless
+// challenge is not in the store, so its expired status is what rejects it.
+func (a *Auth) evictChallenge(challenge trackedChallenge) {
+	challenge.timer.Stop()
+
+	// The challenge may have been consumed in the meantime.
+	if err := a.store.ConsumeChallenge(challenge.k1); err != nil &&
+		!errors.Is(err, ErrChallengeNotFound) {
+		log.Printf("evict challenge: %s", err.Error())
+	}
+
+	// The expired status replaces the pending status, which would have been
+	// removed at the same time.
+	if err := a.store.SetChallengeStatus(
+		challenge.k1,
+		ChallengeStatusExpired,
+		time.Until(challenge.expiresAt),
+	); err != nil {
+		log.Printf("challenge status: %s", err.Error())
+	}
+
+	a.events.Publish(
+		challenge.sessionID,
+		AuthEvent{Type: AuthEventExpired},
+	)
+}
+
+// ChallengeStats returns the statistics of the outstanding k1 challenges
+// issued by this server.
+func (a *Auth) ChallengeStats() ChallengeStats {
+	outstanding, evicted := a.challenges.stats()
+	return ChallengeStats{
+		Outstanding: outstanding,
+		Evicted:     evicted,
+	}
+}
+
+// k1BySessionID finds previously generated k1 challenge with the action if
+// any. Otherwise, it generates a new k1 challenge by randomization and stores
+// to the challenge store for further authentication.
+func (a *Auth) k1BySessionID(
+	sessionID string,
+	action Action,
+	client clientInfo,
+) (issuedChallenge, error) {
+	// Finds previously generated k1 challenge in the store.
+	k1, expiresAt, ok := a.store.ChallengeBySession(sessionID, action)
+	if ok {
+		a.challenges.touch(k1)
+		return issuedChallenge{
+			k1:        k1,
+			action:    action,
+			expiresAt: expiresAt,
+		}, nil
+	}
+
+	// Create a random k1 challenge.
+	challenge := issuedChallenge{
+		k1:        random32BytesHex(),
+		action:    action,
+		expiresAt: time.Now().Add(a.challengeTTL),
+	}
+
+	// Store a mapping between k1 challenge and session ID to the store.
+	if err := a.store.IssueChallenge(
+		challenge.k1,
+		sessionID,
+		challenge.action,
+		time.Until(challenge.expiresAt),
+	); err != nil {
+		return issuedChallenge{}, err
+	}
+
+	if err := a.challengeIssued(sessionID, challenge, client); err != nil {
+		return issuedChallenge{}, err
+	}
+
+	return challenge, nil
+}
+
+// Login logs the user in to the system using digital signature algorithm. It
+// finds the session ID related to the k1 challenge and verifies the given
+// signature. If the session ID is found and the signature is valid, the action
+// of the challenge is performed, which normally resolves the linking key to an
+// account and signs the session in to the account. The state is only used in
+// stateless mode and is ignored otherwise.
+func (a *Auth) Login(
+	k1 string,
+	linkingKey string,
+	signature string,
+	state string,
+) error {
+	return a.login(k1, linkingKey, signature, state, clientInfo{})
+}
+
+// login is Login with the information of the client calling back, which is
+// recorded in the audit log.
+func (a *Auth) login(
+	k1 string,
+	linkingKey string,
+	signature string,
+	state string,
+	client clientInfo,
+) error {
+	event := AuditEvent{
+		Type:       AuditEventLoginFailed,
+		LinkingKey: linkingKey,
+		IP:         client.ip,
+		UserAgent:  client.userAgent,
+	}
+
+	// Find the session ID and the action that the k1 challenge was issued
+	// for.
+	sessionID, action, issuedAt, err := a.sessionByChallenge(k1, state)
+	if err != nil {
+		a.metrics.loginFailed(err)
+		event.Reason = err.Error()
+		a.audit(event)
+		return err
+	}
+	event.SessionHash = publicSessionID(sessionID)
+	event.Action = action
+
+	// Notify that the wallet application has called back with the challenge.
+	a.events.Publish(sessionID, AuthEvent{Type: AuthEventCallback})
+
+	// Stop verifying signatures of the k1 challenge once too many of them
+	// have failed.
+	if a.failedSignatureLimiter.exhausted(k1) {
+		a.events.Publish(sessionID, AuthEvent{
+			Type:   AuthEventFailed,
+			Reason: errTooManyRequests.Error(),
+		})
+		a.metrics.loginFailed(errTooManyRequests)
+		event.Reason = errTooManyRequests.Error()
+		a.audit(event)
+		return errTooManyRequests
+	}
+
+	accountID, err := a.verifyAndLogin(
+		sessionID,
+		action,
+		k1,
+		linkingKey,
+		signature,
+	)
+	if err != nil {
+		a.events.Publish(sessionID, AuthEvent{
+			Type:   AuthEventFailed,
+			Reason: err.Error(),
+		})
+		a.metrics.loginFailed(err)
+		event.Reason = err.Error()
+		a.audit(event)
+		return err
+	}
+
+	// Notify the subscribers of the session, e.g. the login page, that the
+	// challenge has been verified.
+	a.events.Publish(sessionID, AuthEvent{Type: AuthEventVerified})
+	a.metrics.loginSucceeded(action, issuedAt)
+
+	event.Type = AuditEventLoginSucceeded
+	event.AccountID = accountID
+	a.audit(event)
+
+	a.notify(WebhookPayload{
+		Event:      WebhookEventLogin,
+		LinkingKey: linkingKey,
+		AccountID:  accountID,
+		Session:    event.SessionHash,
+		Action:     action,
+	})
+
+	return nil
+}
+
+// verifyAndLogin verifies the signature of the k1 challenge. If the signature
+// is valid and the action is allowed for the linking key, the challenge is
+// consumed and the action is performed. It returns the ID of the account that
+// the linking key belongs to.
+func (a *Auth) verifyAndLogin(
+	sessionID string,
+	action Action,
+	k1 string,
+	linkingKey string,
+	signature string,
+) (string, error) {
+	// Verify the signature with the k1 challenge.
+	ok, err := lnurl.VerifySignature(k1, signature, linkingKey)
+	if err != nil || !ok {
+		a.failedSignatureLimiter.allow(k1)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errInvalidSignature, err.Error())
+	}
+	if !ok {
+		return "", errInvalidSignature
+	}
+
+	// The account is read, modified and saved under the lock, which also
+	// keeps concurrent registrations of the same linking key from creating
+	// two accounts.
+	a.accountMu.Lock()
+	defer a.accountMu.Unlock()
+
+	// Find the account that the linking key belongs to, which determines
+	// whether the action is allowed.
+	account, known := a.store.AccountByKey(linkingKey)
+
+	switch action {
+	case ActionRegister:
+		if known {
+			return "", errKeyRegistered
+		}
+		account = a.newAccount(linkingKey)
+	case ActionLogin:
+		if !known {
+			return "", errKeyNotRegistered
+		}
+	case ActionLink:
+		// The key is linked to the account signed in to the session instead
+		// of signing the session in to another account.
+		sessionAccount, ok := a.Account(sessionID)
+		if !ok {
+			return "", errNotSignedIn
+		}
+		if known && account.ID != sessionAccount.ID {
+			return "", errKeyOfAnotherAccount
+		}
+		if !known {
+			sessionAccount.LinkingKeys = append(
+				sessionAccount.LinkingKeys,
+				linkingKey,
+			)
+		}
+		account = sessionAccount
+	case ActionNone:
+		if !known {
+			account = a.newAccount(linkingKey)
+		}
+	}
+
+	// Consume the challenge so that it cannot be used again.
+	if err := a.consumeChallenge(k1); err != nil {
+		return "", err
+	}
+
+	// The auth action only authorizes the stated action without touching the
+	// session.
+	if action != ActionAuth {
+		// Save the account if the linking key is new to it.
+		if !known {
+			if err := a.store.SaveAccount(account); err != nil {
+				return "", err
+			}
+		}
+
+		// If the signature is correct, sign the session in to the account.
+		if err := a.signIn(
+			sessionID,
+			account.ID,
+			linkingKey,
+		); err != nil {
+			return "", err
+		}
+	}
+
+	if err := a.store.SetChallengeStatus(
+		k1,
+		ChallengeStatusVerified,
+		a.challengeTTL,
+	); err != nil {
+		return "", err
+	}
+
+	return account.ID, nil
+}
+
+// sessionByChallenge finds the session ID and the action that the k1
+// challenge was issued for, and the time at which it was issued. In stateless
+// mode, they are recovered from the state after validating the k1 challenge.
+// Otherwise, they are looked up in the challenge store. The issue time is zero
+// if the challenge was issued by another server sharing the store.
+func (a *Auth) sessionByChallenge(
+	k1 string,
+	state string,
+) (string, Action, time.Time, error) {
+	if a.stateless != nil {
+		sessionID, action, issuedAt, err := a.stateless.verify(k1, state)
+		if err != nil {
+			return "", "", time.Time{}, err
+		}
+
+		// An evicted challenge is still authentic, so it is told apart by
+		// its status.
+		status, _ := a.store.ChallengeStatus(k1)
+		if status == ChallengeStatusExpired {
+			return "", "", time.Time{}, errChallengeExpired
+		}
+
+		return sessionID, action, issuedAt, nil
+	}
+
+	sessionID, action, ok := a.store.SessionByChallenge(k1)
+	if !ok {
+		// The status outlives the challenge, which tells a challenge that is
+		// no longer usable from one that was never issued. A pending
+		// challenge that is gone is being consumed by another callback.
+		status, _ := a.store.ChallengeStatus(k1)
+		switch status {
+		case ChallengeStatusExpired:
+			return "", "", time.Time{}, errChallengeExpired
+		case ChallengeStatusVerified, ChallengeStatusPending:
+			return "", "", time.Time{}, errChallengeUsed
+		default:
+			return "", "", time.Time{}, errChallengeUnknown
+		}
+	}
+
+	issuedAt, _ := a.challenges.issuedAt(k1)
+
+	return sessionID, action, issuedAt, nil
+}
+
+// consumeChallenge marks the k1 challenge as used. In stateless mode, it is
+// recorded as used in the store shared by the servers, which fails if any of
+// them has already accepted it. Otherwise, it is deleted from the challenge
+// store, which fails if a concurrent callback has already consumed it.
+func (a *Auth) consumeChallenge(k1 string) error {
+	if challenge, ok := a.challenges.remove(k1); ok {
+		challenge.timer.Stop()
+	}
+
+	if a.stateless != nil {
+		ok, err := a.store.MarkChallengeUsed(k1, a.challengeTTL)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errChallengeUsed
+		}
+		return nil
+	}
+
+	err := a.store.ConsumeChallenge(k1)
+	if errors.Is(err, ErrChallengeNotFound) {
+		return errChallengeUsed
+	}
+	return err
+}
+
+// Logout logs the user out of the system by removing the given session ID from
+// the session store.
+func (a *Auth) Logout(sessionID string) error {
+	session, ok := a.store.Session(sessionID)
+
+	if err := a.store.DeleteSession(sessionID); err != nil {
+		return err
+	}
+
+	// Only signed-in sessions are audited. The client information is the one
+	// of the latest request of the session.
+	if ok {
+		a.metrics.loggedOut()
+		a.audit(AuditEvent{
+			Type:        AuditEventLogout,
+			SessionHash: publicSessionID(sessionID),
+			AccountID:   session.AccountID,
+			LinkingKey:  session.LinkingKey,
+			IP:          session.IP,
+			UserAgent:   session.UserAgent,
+		})
+		a.notify(WebhookPayload{
+			Event:      WebhookEventLogout,
+			LinkingKey: session.LinkingKey,
+			AccountID:  session.AccountID,
+			Session:    publicSessionID(sessionID),
+		})
+	}
+
+	return nil
+}
+
+// Subscribe subscribes to the authentication events of the session. It
+// returns a channel receiving the events and a function to unsubscribe.
+func (a *Auth) Subscribe(sessionID string) (<-chan AuthEvent, func()) {
+	return a.events.Subscribe(sessionID)
+}
+
+// Shutdown ends the streams of authentication events, so that the open
+// Server-Sent Events and WebSocket connections of the login pages finish and
+// the HTTP server can drain them. It waits until every stream has ended or the
+// context is done. It is meant to be called together with
+// http.Server.Shutdown, which does not wait for WebSocket connections.
+func (a *Auth) Shutdown(ctx context.Context) error {
+	return a.events.Close(ctx)
+}
+
+// AccountID returns the account ID matched with the session ID by reading the
+// session store. If the session is not signed in, it will return false in the
+// second return value.
+func (a *Auth) AccountID(sessionID string) (string, bool) {
+	session, ok := a.store.Session(sessionID)
+	if !ok {
+		return "", false
+	}
+
+	return session.AccountID, true
+}
+
+// Account returns the account signed in to the session. If the session is not
+// signed in, it will return false in the second return value.
+func (a *Auth) Account(sessionID string) (Account, bool) {
+	accountID, ok := a.AccountID(sessionID)
+	if !ok {
+		return Account{}, false
+	}
+
+	return a.store.Account(accountID)
+}
+
+// AccountByID returns the account with the ID. If the account does not exist,
+// it will return false in the second return value.
+func (a *Auth) AccountByID(accountID string) (Account, bool) {
+	return a.store.Account(accountID)
+}
+
+// UpdateProfile replaces the profile of the account signed in to the session.
+func (a *Auth) UpdateProfile(sessionID string, profile Profile) error {
+	a.accountMu.Lock()
+	defer a.accountMu.Unlock()
+
+	account, ok := a.Account(sessionID)
+	if !ok {
+		return errNotSignedIn
+	}
+
+	account.Profile = profile
+	return a.store.SaveAccount(account)
+}
+
+// UnlinkKey removes the linking key from the account signed in to the
+// session. The last linking key of the account cannot be removed, otherwise
+// the user would no longer be able to log in to the account.
+func (a *Auth) UnlinkKey(sessionID string, linkingKey string) error {
+	a.accountMu.Lock()
+	defer a.accountMu.Unlock()
+
+	account, ok := a.Account(sessionID)
+	if !ok {
+		return errNotSignedIn
+	}
+
+	linkingKeys := make([]string, 0, len(account.LinkingKeys))
+	for _, key := range account.LinkingKeys {
+		if key != linkingKey {
+			linkingKeys = append(linkingKeys, key)
+		}
+	}
+
+	if len(linkingKeys) == len(account.LinkingKeys) {
+		return errors.New("linking key does not belong to the account")
+	}
+	if len(linkingKeys) == 0 {
+		return errors.New("cannot unlink the last linking key")
+	}
+
+	account.LinkingKeys = linkingKeys
+	return a.store.SaveAccount(account)
+}
+
+// newAccount creates a new account with the linking key as its only key. The
+// account ID is derived from the linking key, so that registering the key again
+// after the store is reset gives the account the same ID, e.g. the subject of
+// an OpenID Connect provider. If the key has been unlinked from the account
+// with that ID, which still exists, the new account gets a random ID instead.
+// Either way, the account keeps its ID whichever keys are linked or unlinked
+// later.
+func (a *Auth) newAccount(linkingKey string) Account {
+	accountID := linkingKeyAccountID(linkingKey)
+	if _, ok := a.store.Account(accountID); ok {
+		accountID = random32BytesHex()
+	}
+
+	return Account{
+		ID:          accountID,
+		LinkingKeys: []string{linkingKey},
+		CreatedAt:   time.Now(),
+	}
+}
+
+// linkingKeyAccountID derives the ID of the account registered with the
+// linking key, which is the hexadecimal SHA-256 hash of the key.
+func linkingKeyAccountID(linkingKey string) string {
+	sum := sha256.Sum256([]byte(linkingKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// random32BytesHex generates a random 32-byte data in a hexadecimal string
+// format.
+func random32BytesHex() string {
+	data := make([]byte, 32)
+	_, _ = rand.Read(data)
+	return hex.EncodeToString(data)
+}
@@ -0,0 +1,888 @@
+package lnurlauth_test
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lnurl "github.com/fiatjaf/go-lnurl"
+	"github.com/gin-gonic/gin"
+	"github.com/sunboyy/lnurlauth/pkg"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+const (
+	loginPagePrefix = "login:"
+	indexPagePrefix = "account:"
+)
+
+// newGinServer starts a server with the Gin handlers. The login page renders
+// only a prefix, since the challenge is requested by the page, and the index
+// page renders only the account ID so that the test can tell them apart.
+func newGinServer(
+	t *testing.T,
+	opts ...lnurlauth.Option,
+) *httptest.Server {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	auth, err := lnurlauth.NewAuth(server.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := lnurlauth.NewHandler(auth)
+
+	templates := template.New("")
+	template.Must(templates.New("login.tmpl").Parse(
+		loginPagePrefix + "{{.K1}}",
+	))
+	template.Must(templates.New("index.tmpl").Parse(
+		indexPagePrefix + "{{.Account.ID}}",
+	))
+	r.SetHTMLTemplate(templates)
+
+	r.GET("/", auth.Middleware, handler.Home)
+	r.POST("/api/challenge", auth.Middleware, handler.CreateChallenge)
+	r.GET("/login", handler.Login)
+	r.GET("/logout", auth.Middleware, handler.Logout)
+	r.POST("/api/token", auth.Middleware, handler.CreateToken)
+	r.POST("/api/token/refresh", handler.RefreshToken)
+	r.GET("/api/sessions", auth.Middleware, handler.Sessions)
+	r.DELETE("/api/sessions/:id", auth.Middleware, handler.RevokeSession)
+
+	return server
+}
+
+// newHTTPServer starts a server with the net/http handlers of the JSON API.
+func newHTTPServer(
+	t *testing.T,
+	opts ...lnurlauth.Option,
+) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	auth, err := lnurlauth.NewAuth(server.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := lnurlauth.NewHandler(auth)
+
+	mux.Handle(
+		"/api/challenge",
+		auth.HTTPLimitChallenges(
+			auth.HTTPMiddleware(http.HandlerFunc(handler.ServeChallenge)),
+		),
+	)
+	mux.HandleFunc("/api/challenge/status", handler.ServeChallengeStatus)
+	mux.Handle(
+		"/api/sessions",
+		auth.HTTPMiddleware(http.HandlerFunc(handler.ServeSessions)),
+	)
+	mux.Handle(
+		"/login",
+		auth.HTTPLimitCallbacks(http.HandlerFunc(handler.ServeLogin)),
+	)
+	mux.Handle(
+		"/login/ws",
+		auth.HTTPMiddleware(http.HandlerFunc(handler.ServeLoginWebSocket)),
+	)
+	mux.Handle(
+		"/logout",
+		auth.HTTPMiddleware(http.HandlerFunc(handler.ServeLogout)),
+	)
+	mux.Handle(
+		"/account/unlink",
+		auth.HTTPMiddleware(http.HandlerFunc(handler.ServeUnlinkKey)),
+	)
+
+	return server
+}
+
+// newBrowser returns a client keeping cookies like a browser. Redirects are
+// not followed so that the test sees the response of each endpoint.
+func newBrowser() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sessionCookie returns the value of the session cookie that the browser
+// keeps for the server.
+func sessionCookie(t *testing.T, browser *http.Client, serverURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range browser.Jar.Cookies(u) {
+		if cookie.Name == "lnurl_sess" {
+			return cookie.Value
+		}
+	}
+
+	t.Fatal("session cookie is not set")
+	return ""
+}
+
+// walletAccountID returns the ID of the account that the linking key of the
+// wallet belongs to.
+func walletAccountID(
+	t *testing.T,
+	store lnurlauth.Store,
+	w lnurlauthtest.Wallet,
+) string {
+	t.Helper()
+
+	account, ok := store.AccountByKey(w.LinkingKey())
+	if !ok {
+		t.Fatal("linking key is not registered")
+	}
+	return account.ID
+}
+
+// loginURL decodes the LNURL of the challenge into the login URL that the
+// wallet calls back, as if the wallet has scanned the QR code.
+func loginURL(t *testing.T, challenge lnurlauth.AuthChallenge) *url.URL {
+	t.Helper()
+
+	decoded, err := lnurl.LNURLDecode(
+		strings.TrimPrefix(challenge.LNURL, pkg.LNURLProtocolPrefix),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func TestMiddlewareLoginCycle(t *testing.T) {
+	store := lnurlauth.NewMemoryStore()
+	server := newGinServer(t, lnurlauth.WithStore(store))
+	browser := newBrowser()
+
+	// The first visit sets the session cookie and shows the login page,
+	// which then requests the challenge.
+	page := getPage(t, browser, server.URL+"/")
+	if page != loginPagePrefix {
+		t.Fatalf("expected login page, got %q", page)
+	}
+	anonymousSessionID := sessionCookie(t, browser, server.URL)
+
+	// The challenge comes with a new session ID, so a session ID known before
+	// the challenge, e.g. one planted in the browser by an attacker, is never
+	// signed in.
+	k1 := createChallenge(t, browser, server.URL)
+	challengeSessionID := sessionCookie(t, browser, server.URL)
+	if challengeSessionID == anonymousSessionID {
+		t.Fatal("expected session ID to be replaced with the challenge")
+	}
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Login(server.URL, k1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+
+	// The browser is signed in with the session ID of the challenge, which
+	// stays the same from now on.
+	page = getPage(t, browser, server.URL+"/")
+	if page != indexPagePrefix+walletAccountID(t, store, w) {
+		t.Fatalf("expected index page of the account, got %q", page)
+	}
+	if sessionCookie(t, browser, server.URL) != challengeSessionID {
+		t.Fatal("expected session ID to stay after signing in")
+	}
+
+	// Another browser does not share the session.
+	if page := getPage(t, newBrowser(), server.URL+"/"); !strings.HasPrefix(
+		page,
+		loginPagePrefix,
+	) {
+		t.Fatalf("expected login page for another browser, got %q", page)
+	}
+
+	// The session ID known before the challenge is not signed in.
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "lnurl_sess", Value: anonymousSessionID})
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.HasPrefix(string(dat), loginPagePrefix) {
+		t.Fatalf("expected login page for the planted session ID, got %q", dat)
+	}
+
+	// Logging out shows the login page with a new challenge.
+	res, err = browser.Get(server.URL + "/logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("expected redirect after logout, got %d", res.StatusCode)
+	}
+
+	if page := getPage(t, browser, server.URL+"/"); page != loginPagePrefix {
+		t.Fatalf("expected login page after logout, got %q", page)
+	}
+	if createChallenge(t, browser, server.URL) == k1 {
+		t.Fatal("expected a new challenge after logout")
+	}
+}
+
+func TestHTTPMiddlewareLoginCycle(t *testing.T) {
+	server := newHTTPServer(t)
+	browser := newBrowser()
+
+	var challenge lnurlauth.AuthChallenge
+	status := postJSON(t, browser, server.URL+"/api/challenge", &challenge)
+	if status != http.StatusOK {
+		t.Fatalf("challenge: status %d", status)
+	}
+
+	// The session is not signed in yet.
+	var sessions []lnurlauth.SessionInfo
+	status = getJSON(t, browser, server.URL+"/api/sessions", &sessions)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized before login, got %d", status)
+	}
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Login(server.URL, challenge.K1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+
+	status = getJSON(t, browser, server.URL+"/api/sessions", &sessions)
+	if status != http.StatusOK {
+		t.Fatalf("expected sessions after login, got %d", status)
+	}
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("expected the current session only, got %+v", sessions)
+	}
+
+	res, err := browser.Get(server.URL + "/logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	status = getJSON(t, browser, server.URL+"/api/sessions", &sessions)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized after logout, got %d", status)
+	}
+}
+
+func TestLoginRejectsInvalidSignature(t *testing.T) {
+	server := newHTTPServer(t)
+	browser := newBrowser()
+
+	var challenge lnurlauth.AuthChallenge
+	postJSON(t, browser, server.URL+"/api/challenge", &challenge)
+
+	// The challenge is signed by one wallet but claimed by another.
+	signer := lnurlauthtest.NewWallet(t)
+	signature, err := signer.Sign(challenge.K1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(server.URL + "/login?" + url.Values{
+		"tag": {"login"},
+		"k1":  {challenge.K1},
+		"sig": {signature},
+		"key": {lnurlauthtest.NewWallet(t).LinkingKey()},
+	}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", res.StatusCode)
+	}
+
+	var sessions []lnurlauth.SessionInfo
+	status := getJSON(t, browser, server.URL+"/api/sessions", &sessions)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized, got %d", status)
+	}
+}
+
+func TestLoginRejectsExpiredAndUnknownChallenges(t *testing.T) {
+	server := newHTTPServer(
+		t,
+		lnurlauth.WithChallengeTTL(time.Millisecond*200),
+	)
+	browser := newBrowser()
+	w := lnurlauthtest.NewWallet(t)
+
+	// A k1 challenge that was never issued is unknown.
+	unknownK1 := strings.Repeat("ab", 32)
+	if err := w.Login(server.URL, unknownK1); err == nil ||
+		err.Error() != "unknown k1 challenge" {
+		t.Fatalf("expected unknown challenge, got %v", err)
+	}
+
+	var challenge lnurlauth.AuthChallenge
+	postJSON(t, browser, server.URL+"/api/challenge", &challenge)
+	if !challenge.ExpiresAt.After(time.Now()) ||
+		time.Until(challenge.ExpiresAt) > time.Millisecond*200 {
+		t.Fatalf("unexpected expiration time %s", challenge.ExpiresAt)
+	}
+
+	// The status of the expired challenge is kept for another TTL.
+	time.Sleep(time.Millisecond * 300)
+
+	if err := w.Login(server.URL, challenge.K1); err == nil ||
+		err.Error() != "k1 challenge has expired" {
+		t.Fatalf("expected expired challenge, got %v", err)
+	}
+
+	// The session gets a new challenge once the previous one expires.
+	var rotated lnurlauth.AuthChallenge
+	postJSON(t, browser, server.URL+"/api/challenge", &rotated)
+	if rotated.K1 == challenge.K1 {
+		t.Fatal("expected a new challenge after expiration")
+	}
+	if err := w.Login(server.URL, rotated.K1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+}
+
+func TestChallengeStatus(t *testing.T) {
+	server := newHTTPServer(
+		t,
+		lnurlauth.WithChallengeTTL(time.Millisecond*200),
+	)
+	w := lnurlauthtest.NewWallet(t)
+	statusOf := func(k1 string) (int, lnurlauth.ChallengeStatus) {
+		t.Helper()
+
+		var res struct {
+			Status lnurlauth.ChallengeStatus `json:"status"`
+		}
+		code := getJSON(
+			t,
+			newBrowser(),
+			server.URL+"/api/challenge/status?k1="+k1,
+			&res,
+		)
+		return code, res.Status
+	}
+
+	// The challenge is pending until the wallet signs it.
+	verifiedK1 := createChallenge(t, newBrowser(), server.URL)
+	if code, status := statusOf(verifiedK1); code != http.StatusOK ||
+		status != lnurlauth.ChallengeStatusPending {
+		t.Fatalf("expected pending, got %d %s", code, status)
+	}
+	if err := w.Login(server.URL, verifiedK1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+	if code, status := statusOf(verifiedK1); code != http.StatusOK ||
+		status != lnurlauth.ChallengeStatusVerified {
+		t.Fatalf("expected verified, got %d %s", code, status)
+	}
+
+	// The challenge that is not signed in time expires.
+	expiredK1 := createChallenge(t, newBrowser(), server.URL)
+	time.Sleep(time.Millisecond * 300)
+	if code, status := statusOf(expiredK1); code != http.StatusOK ||
+		status != lnurlauth.ChallengeStatusExpired {
+		t.Fatalf("expected expired, got %d %s", code, status)
+	}
+
+	// A challenge that was never issued is unknown.
+	if code, _ := statusOf(strings.Repeat("ab", 32)); code !=
+		http.StatusNotFound {
+		t.Fatalf("expected not found, got %d", code)
+	}
+}
+
+func TestChallengesAreConsumedOnce(t *testing.T) {
+	fileStore, err := lnurlauth.NewFileStore(
+		filepath.Join(t.TempDir(), "store.json"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+
+	for name, store := range map[string]lnurlauth.Store{
+		"memory": lnurlauth.NewMemoryStore(),
+		"file":   fileStore,
+	} {
+		// Only one of the concurrent consumers removes the challenge.
+		if err := store.IssueChallenge(
+			"k1",
+			"session",
+			lnurlauth.ActionNone,
+			time.Minute,
+		); err != nil {
+			t.Fatal(err)
+		}
+		consumed := make(chan error, 10)
+		for i := 0; i < cap(consumed); i++ {
+			go func() {
+				consumed <- store.ConsumeChallenge("k1")
+			}()
+		}
+		succeeded := 0
+		for i := 0; i < cap(consumed); i++ {
+			err := <-consumed
+			if err == nil {
+				succeeded++
+			} else if !errors.Is(err, lnurlauth.ErrChallengeNotFound) {
+				t.Errorf("%s: unexpected error %s", name, err)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%s: expected one consumer, got %d", name, succeeded)
+		}
+
+		server := newHTTPServer(t, lnurlauth.WithStore(store))
+		k1 := createChallenge(t, newBrowser(), server.URL)
+
+		// A replayed callback racing with the first one must not sign in
+		// again, e.g. creating a second account for the new linking key.
+		w := lnurlauthtest.NewWallet(t)
+		start := make(chan struct{})
+		errs := make(chan error, 10)
+		var wg sync.WaitGroup
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				errs <- w.Login(server.URL, k1)
+			}()
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+
+		succeeded = 0
+		for err := range errs {
+			if err == nil {
+				succeeded++
+			} else if err.Error() != "k1 challenge has already been used" {
+				t.Errorf("%s: unexpected error %s", name, err)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%s: expected one login, got %d", name, succeeded)
+		}
+	}
+}
+
+func TestActionsRegisterLoginAndLinkKeys(t *testing.T) {
+	store := lnurlauth.NewMemoryStore()
+	server := newHTTPServer(t, lnurlauth.WithStore(store))
+
+	// scan requests a challenge with the action for the browser and lets the
+	// wallet sign it.
+	scan := func(
+		w lnurlauthtest.Wallet,
+		browser *http.Client,
+		action lnurlauth.Action,
+	) error {
+		return w.Callback(loginURL(
+			t,
+			createActionChallenge(t, browser, server.URL, action),
+		).String())
+	}
+
+	// Unknown actions are rejected before a challenge is issued.
+	var challenge lnurlauth.AuthChallenge
+	if status := postJSON(
+		t,
+		newBrowser(),
+		server.URL+"/api/challenge?action=transfer",
+		&challenge,
+	); status != http.StatusBadRequest {
+		t.Fatalf("expected unknown action to be rejected, got %d", status)
+	}
+
+	first := lnurlauthtest.NewWallet(t)
+	second := lnurlauthtest.NewWallet(t)
+	third := lnurlauthtest.NewWallet(t)
+	if err := scan(first, newBrowser(), lnurlauth.ActionLogin); err == nil ||
+		err.Error() != "linking key is not registered" {
+		t.Fatalf("expected login of unknown key to fail, got %v", err)
+	}
+
+	browser := newBrowser()
+	if err := scan(first, browser, lnurlauth.ActionRegister); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	accountID := walletAccountID(t, store, first)
+	if err := scan(first, newBrowser(), lnurlauth.ActionRegister); err == nil ||
+		err.Error() != "linking key is already registered" {
+		t.Fatalf("expected second registration to fail, got %v", err)
+	}
+	if err := scan(first, newBrowser(), lnurlauth.ActionLogin); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+
+	// Linking requires a signed-in session and a key of no other account.
+	if err := scan(second, newBrowser(), lnurlauth.ActionLink); err == nil ||
+		err.Error() != "session is not signed in" {
+		t.Fatalf("expected link without session to fail, got %v", err)
+	}
+	if err := scan(second, browser, lnurlauth.ActionLink); err != nil {
+		t.Fatalf("link: %s", err)
+	}
+	if linked := walletAccountID(t, store, second); linked != accountID {
+		t.Fatalf("expected key to be linked to %s, got %s", accountID, linked)
+	}
+	if err := scan(third, newBrowser(), lnurlauth.ActionRegister); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	if err := scan(third, browser, lnurlauth.ActionLink); err == nil ||
+		err.Error() != "linking key belongs to another account" {
+		t.Fatalf("expected link of another account's key to fail, got %v", err)
+	}
+
+	// unlink removes the key from the account of the browser and returns the
+	// status code.
+	unlink := func(w lnurlauthtest.Wallet) int {
+		res, err := browser.PostForm(
+			server.URL+"/account/unlink",
+			url.Values{"key": {w.LinkingKey()}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// An unlinked key is free to register a new account.
+	if status := unlink(second); status != http.StatusSeeOther {
+		t.Fatalf("expected key to be unlinked, got %d", status)
+	}
+	if err := scan(second, newBrowser(), lnurlauth.ActionLogin); err == nil ||
+		err.Error() != "linking key is not registered" {
+		t.Fatalf("expected login of unlinked key to fail, got %v", err)
+	}
+	if err := scan(second, newBrowser(), lnurlauth.ActionRegister); err != nil {
+		t.Fatalf("register unlinked key: %s", err)
+	}
+	if walletAccountID(t, store, second) == accountID {
+		t.Fatal("expected unlinked key to register a new account")
+	}
+
+	// The last key of the account cannot be unlinked.
+	if status := unlink(first); status != http.StatusBadRequest {
+		t.Fatalf("expected last key to stay linked, got %d", status)
+	}
+}
+
+func TestAccountIDIsDerivedFromTheLinkingKey(t *testing.T) {
+	store := lnurlauth.NewMemoryStore()
+	server := newHTTPServer(t, lnurlauth.WithStore(store))
+	w := lnurlauthtest.NewWallet(t)
+	browser := newBrowser()
+	signIn(t, w, browser, server.URL)
+	accountID := walletAccountID(t, store, w)
+
+	// The key registers an account with the same ID once the store is reset.
+	resetStore := lnurlauth.NewMemoryStore()
+	resetServer := newHTTPServer(t, lnurlauth.WithStore(resetStore))
+	signIn(t, w, newBrowser(), resetServer.URL)
+	if id := walletAccountID(t, resetStore, w); id != accountID {
+		t.Fatalf("expected account %s after reset, got %s", accountID, id)
+	}
+
+	// The account keeps its ID after the key is unlinked from it, so the key
+	// registers an account with another ID.
+	other := lnurlauthtest.NewWallet(t)
+	challenge := createActionChallenge(
+		t,
+		browser,
+		server.URL,
+		lnurlauth.ActionLink,
+	)
+	if err := other.Callback(loginURL(t, challenge).String()); err != nil {
+		t.Fatalf("link: %s", err)
+	}
+	res, err := browser.PostForm(
+		server.URL+"/account/unlink",
+		url.Values{"key": {w.LinkingKey()}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("unlink: status %d", res.StatusCode)
+	}
+	signIn(t, w, newBrowser(), server.URL)
+	if id := walletAccountID(t, store, w); id == accountID {
+		t.Fatal("expected the unlinked key to register a new account")
+	}
+	if id := walletAccountID(t, store, other); id != accountID {
+		t.Fatalf("expected linked key to stay in %s, got %s", accountID, id)
+	}
+}
+
+func TestConcurrentAccountUpdatesKeepEveryKey(t *testing.T) {
+	store := lnurlauth.NewMemoryStore()
+	server := newHTTPServer(t, lnurlauth.WithStore(slowAccountStore{store}))
+
+	owner, unlinked := lnurlauthtest.NewWallet(t), lnurlauthtest.NewWallet(t)
+	browser := newBrowser()
+	signIn(t, owner, browser, server.URL)
+	challenge := createActionChallenge(
+		t,
+		browser,
+		server.URL,
+		lnurlauth.ActionLink,
+	)
+	if err := unlinked.Callback(loginURL(t, challenge).String()); err != nil {
+		t.Fatalf("link: %s", err)
+	}
+
+	// Every device of the account links another key while one of them
+	// unlinks a key, all at the same time.
+	linked := make([]lnurlauthtest.Wallet, 8)
+	challenges := make([]lnurlauth.AuthChallenge, len(linked))
+	for i := range linked {
+		linked[i] = lnurlauthtest.NewWallet(t)
+		device := newBrowser()
+		signIn(t, owner, device, server.URL)
+		challenges[i] = createActionChallenge(
+			t,
+			device,
+			server.URL,
+			lnurlauth.ActionLink,
+		)
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range linked {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if err := linked[i].Callback(
+				loginURL(t, challenges[i]).String(),
+			); err != nil {
+				t.Errorf("link: %s", err)
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+		res, err := browser.PostForm(
+			server.URL+"/account/unlink",
+			url.Values{"key": {unlinked.LinkingKey()}},
+		)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusSeeOther {
+			t.Errorf("unlink: status %d", res.StatusCode)
+		}
+	}()
+	close(start)
+	wg.Wait()
+
+	account, ok := store.AccountByKey(owner.LinkingKey())
+	if !ok {
+		t.Fatal("expected the account of the owner")
+	}
+	expected := map[string]bool{owner.LinkingKey(): true}
+	for _, w := range linked {
+		expected[w.LinkingKey()] = true
+	}
+	if len(account.LinkingKeys) != len(expected) {
+		t.Fatalf("expected %d keys, got %v", len(expected), account.LinkingKeys)
+	}
+	for _, key := range account.LinkingKeys {
+		if !expected[key] {
+			t.Fatalf("unexpected key %s in %v", key, account.LinkingKeys)
+		}
+	}
+}
+
+// slowAccountStore is a store that takes a while to read accounts, so that
+// concurrent updates of an account overlap.
+type slowAccountStore struct {
+	lnurlauth.Store
+}
+
+// Account waits after reading the account, so that the account read by a
+// concurrent update is outdated by the time it is saved.
+func (s slowAccountStore) Account(accountID string) (lnurlauth.Account, bool) {
+	account, ok := s.Store.Account(accountID)
+	time.Sleep(20 * time.Millisecond)
+	return account, ok
+}
+
+// createChallenge requests the challenge of the browser's session through the
+// challenge API and returns its k1.
+func createChallenge(t *testing.T, browser *http.Client, serverURL string) string {
+	t.Helper()
+
+	var challenge lnurlauth.AuthChallenge
+	status := postJSON(t, browser, serverURL+"/api/challenge", &challenge)
+	if status != http.StatusOK {
+		t.Fatalf("challenge: status %d", status)
+	}
+
+	return challenge.K1
+}
+
+// signIn signs the browser in with the wallet through the challenge API.
+func signIn(
+	t *testing.T,
+	w lnurlauthtest.Wallet,
+	browser *http.Client,
+	serverURL string,
+) {
+	t.Helper()
+
+	k1 := createChallenge(t, browser, serverURL)
+	if err := w.Login(serverURL, k1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+}
+
+// createActionChallenge requests a k1 challenge with the action for the
+// browser.
+func createActionChallenge(
+	t *testing.T,
+	browser *http.Client,
+	serverURL string,
+	action lnurlauth.Action,
+) lnurlauth.AuthChallenge {
+	t.Helper()
+
+	var challenge lnurlauth.AuthChallenge
+	status := postJSON(
+		t,
+		browser,
+		serverURL+"/api/challenge?action="+string(action),
+		&challenge,
+	)
+	if status != http.StatusOK {
+		t.Fatalf("challenge: status %d", status)
+	}
+	if challenge.Action != action {
+		t.Fatalf("expected action %q, got %q", action, challenge.Action)
+	}
+
+	return challenge
+}
+
+// getPage requests the page with the browser and returns the response body.
+func getPage(t *testing.T, browser *http.Client, u string) string {
+	t.Helper()
+
+	res, err := browser.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	dat, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", res.StatusCode, dat)
+	}
+
+	return string(dat)
+}
+
+// getJSON requests the URL with the browser, decodes the JSON response of
+// successful requests and returns the status code.
+func getJSON(
+	t *testing.T,
+	browser *http.Client,
+	u string,
+	v interface{},
+) int {
+	t.Helper()
+
+	return doJSON(t, browser, http.MethodGet, u, v)
+}
+
+// postJSON is the POST equivalent of getJSON.
+func postJSON(
+	t *testing.T,
+	browser *http.Client,
+	u string,
+	v interface{},
+) int {
+	t.Helper()
+
+	return doJSON(t, browser, http.MethodPost, u, v)
+}
+
+// doJSON sends the request with the browser, decodes the JSON response of
+// successful requests and returns the status code.
+func doJSON(
+	t *testing.T,
+	browser *http.Client,
+	method string,
+	u string,
+	v interface{},
+) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := browser.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+	}
+
+	return res.StatusCode
+}
@@ -0,0 +1,145 @@
+package lnurlauth
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// defaultMaxChallenges is the maximum number of outstanding k1 challenges
+// tracked when WithMaxChallenges is not given.
+const defaultMaxChallenges = 10000
+
+// trackedChallenge is an outstanding k1 challenge issued by this server.
+type trackedChallenge struct {
+	k1        string
+	sessionID string
+	issuedAt  time.Time
+	expiresAt time.Time
+
+	// timer fires when the challenge expires.
+	timer *time.Timer
+}
+
+// challengeLRU tracks the outstanding k1 challenges issued by this server in
+// the order of their latest use, so that the least recently used challenges
+// can be evicted once there are too many of them. A nil challengeLRU tracks
+// nothing.
+type challengeLRU struct {
+	// max is the maximum number of outstanding challenges.
+	max int
+
+	mu sync.Mutex
+
+	// order lists the challenges from the most recently used to the least
+	// recently used.
+	order *list.List
+
+	// elements is a mapping between k1 challenge and its element in order.
+	elements map[string]*list.Element
+
+	// evicted is the number of challenges evicted so far.
+	evicted uint64
+}
+
+// newChallengeLRU is a constructor of challengeLRU.
+func newChallengeLRU(max int) *challengeLRU {
+	return &challengeLRU{
+		max:      max,
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+// add tracks the newly issued challenge as the most recently used one. It
+// returns the least recently used challenges that no longer fit, which the
+// caller must remove from the store.
+func (l *challengeLRU) add(challenge trackedChallenge) []trackedChallenge {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.elements[challenge.k1] = l.order.PushFront(challenge)
+
+	var evicted []trackedChallenge
+	for l.order.Len() > l.max {
+		element := l.order.Back()
+		l.order.Remove(element)
+
+		challenge := element.Value.(trackedChallenge)
+		delete(l.elements, challenge.k1)
+		evicted = append(evicted, challenge)
+	}
+	l.evicted += uint64(len(evicted))
+
+	return evicted
+}
+
+// touch marks the challenge as the most recently used one.
+func (l *challengeLRU) touch(k1 string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.elements[k1]; ok {
+		l.order.MoveToFront(element)
+	}
+}
+
+// issuedAt returns the time at which the tracked challenge was issued. If the
+// challenge is not tracked, it will return false in the second return value.
+func (l *challengeLRU) issuedAt(k1 string) (time.Time, bool) {
+	if l == nil {
+		return time.Time{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.elements[k1]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return element.Value.(trackedChallenge).issuedAt, true
+}
+
+// remove stops tracking the challenge, e.g. when it has been used or has
+// expired. If the challenge is not tracked, it will return false in the second
+// return value.
+func (l *challengeLRU) remove(k1 string) (trackedChallenge, bool) {
+	if l == nil {
+		return trackedChallenge{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.elements[k1]
+	if !ok {
+		return trackedChallenge{}, false
+	}
+	l.order.Remove(element)
+	delete(l.elements, k1)
+
+	return element.Value.(trackedChallenge), true
+}
+
+// stats returns the number of outstanding challenges and the number of
+// challenges evicted so far.
+func (l *challengeLRU) stats() (int, uint64) {
+	if l == nil {
+		return 0, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.order.Len(), l.evicted
+}
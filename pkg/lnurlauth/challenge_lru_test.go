@@ -0,0 +1,55 @@
+package lnurlauth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestLeastRecentlyUsedChallengesAreEvicted(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	auth, err := lnurlauth.NewAuth(server.URL, lnurlauth.WithMaxChallenges(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.HandleFunc("/login", lnurlauth.NewHandler(auth).ServeLogin)
+
+	challenge := func(sessionID string) string {
+		challenge, err := auth.Challenge(sessionID, lnurlauth.ActionNone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return challenge.K1
+	}
+	firstK1 := challenge("first")
+	secondK1 := challenge("second")
+
+	// Requesting the first challenge again makes the second one the least
+	// recently used.
+	challenge("first")
+	challenge("third")
+
+	stats := auth.ChallengeStats()
+	if stats.Outstanding != 2 || stats.Evicted != 1 {
+		t.Fatalf("unexpected challenge stats %+v", stats)
+	}
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Login(server.URL, secondK1); err == nil ||
+		err.Error() != "k1 challenge has expired" {
+		t.Fatalf("expected evicted challenge to expire, got %v", err)
+	}
+	if err := w.Login(server.URL, firstK1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+
+	if stats := auth.ChallengeStats(); stats.Outstanding != 1 {
+		t.Fatalf("expected used challenge to be released, got %+v", stats)
+	}
+}
@@ -0,0 +1,99 @@
+package lnurlauth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookieConfig contains the attributes of the session cookie.
+type CookieConfig struct {
+	// Name is the name of the cookie. It is `lnurl_sess` if empty.
+	Name string
+
+	// Domain is the domain attribute of the cookie. If it is empty, the cookie
+	// is only sent to the host that set it.
+	Domain string
+
+	// Path is the path attribute of the cookie. It is `/` if empty.
+	Path string
+
+	// Secure restricts the cookie to HTTPS connections.
+	Secure bool
+
+	// SameSite is the SameSite attribute of the cookie. It is
+	// http.SameSiteLaxMode if unset.
+	SameSite http.SameSite
+}
+
+// defaultCookieConfig returns the cookie attributes used when WithCookie is
+// not given. The cookie is secure if the server is served over HTTPS.
+func defaultCookieConfig(hostname string) CookieConfig {
+	return CookieConfig{
+		Secure: strings.HasPrefix(hostname, "https://"),
+	}
+}
+
+// withDefaults fills the unset attributes with their default values and
+// validates the combination of the attributes.
+func (c CookieConfig) withDefaults() (CookieConfig, error) {
+	if c.Name == "" {
+		c.Name = sessionKey
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+
+	// Browsers reject SameSite=None cookies without the Secure attribute.
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return CookieConfig{}, errors.New(
+			"cookie with SameSite=None must be secure",
+		)
+	}
+
+	return c, nil
+}
+
+// setSessionCookie sets the session cookie to the response. The cookie lives
+// as long as the absolute timeout of the sessions.
+func (a *Auth) setSessionCookie(w http.ResponseWriter, sessionID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookie.Name,
+		Value:    sessionID,
+		MaxAge:   int(a.absoluteTimeout / time.Second),
+		Path:     a.cookie.Path,
+		Domain:   a.cookie.Domain,
+		Secure:   a.cookie.Secure,
+		HttpOnly: true,
+		SameSite: a.cookie.SameSite,
+	})
+}
+
+// clearSessionCookie removes the session cookie from the browser.
+func (a *Auth) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookie.Name,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     a.cookie.Path,
+		Domain:   a.cookie.Domain,
+		Secure:   a.cookie.Secure,
+		HttpOnly: true,
+		SameSite: a.cookie.SameSite,
+	})
+}
+
+// sessionCookie returns the session ID in the session cookie of the request.
+// If the request has no session cookie, it will return false in the second
+// return value.
+func (a *Auth) sessionCookie(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(a.cookie.Name)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
@@ -0,0 +1,91 @@
+package lnurlauth_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+)
+
+func TestSessionCookieAttributes(t *testing.T) {
+	// challengeCookie requests a challenge with the session cookie, if any,
+	// and returns the session cookie set by the response.
+	challengeCookie := func(
+		serverURL string,
+		name string,
+		sent *http.Cookie,
+	) *http.Cookie {
+		req, err := http.NewRequest(
+			http.MethodPost,
+			serverURL+"/api/challenge",
+			nil,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != nil {
+			req.AddCookie(&http.Cookie{Name: sent.Name, Value: sent.Value})
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		var set []*http.Cookie
+		for _, cookie := range res.Cookies() {
+			if cookie.Name == name {
+				set = append(set, cookie)
+			}
+		}
+		if len(set) != 1 {
+			t.Fatalf("expected one session cookie, got %+v", res.Cookies())
+		}
+		return set[0]
+	}
+
+	server := newHTTPServer(t)
+	cookie := challengeCookie(server.URL, "lnurl_sess", nil)
+	if !cookie.HttpOnly ||
+		cookie.Secure ||
+		cookie.SameSite != http.SameSiteLaxMode ||
+		cookie.Path != "/" ||
+		cookie.Domain != "" ||
+		cookie.MaxAge != int((24*time.Hour)/time.Second) {
+		t.Fatalf("unexpected default cookie %+v", cookie)
+	}
+
+	server = newHTTPServer(t, lnurlauth.WithCookie(lnurlauth.CookieConfig{
+		Name:     "sid",
+		Domain:   "example.com",
+		Path:     "/app",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}))
+	cookie = challengeCookie(server.URL, "sid", nil)
+	if !cookie.HttpOnly ||
+		!cookie.Secure ||
+		cookie.SameSite != http.SameSiteStrictMode ||
+		cookie.Path != "/app" ||
+		cookie.Domain != "example.com" {
+		t.Fatalf("unexpected configured cookie %+v", cookie)
+	}
+
+	// The next challenge replaces the session ID with the same attributes.
+	replaced := challengeCookie(server.URL, "sid", cookie)
+	if replaced.Value == cookie.Value ||
+		!replaced.Secure ||
+		replaced.Path != "/app" {
+		t.Fatalf("unexpected replaced cookie %+v", replaced)
+	}
+
+	if _, err := lnurlauth.NewAuth(
+		"http://example.com",
+		lnurlauth.WithCookie(lnurlauth.CookieConfig{
+			SameSite: http.SameSiteNoneMode,
+		}),
+	); err == nil {
+		t.Fatal("expected SameSite=None without Secure to be rejected")
+	}
+}
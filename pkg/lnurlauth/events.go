@@ -0,0 +1,116 @@
+package lnurlauth
+
+import (
+	"context"
+	"sync"
+)
+
+// eventBufferSize is the number of events buffered for each subscriber. If a
+// subscriber does not keep up, further events are dropped for the subscriber.
+const eventBufferSize = 8
+
+// EventBroker delivers authentication events of a session to all subscribers
+// of the session. Events are delivered only within the server process.
+type EventBroker struct {
+	mu sync.Mutex
+
+	// subscribers maps session ID to the set of channels subscribing to the
+	// events of the session.
+	subscribers map[string]map[chan AuthEvent]struct{}
+
+	// closed is set once the broker is closed, after which no subscription
+	// is accepted.
+	closed bool
+
+	// active counts the subscriptions that have not been unsubscribed.
+	active sync.WaitGroup
+}
+
+// NewEventBroker is a constructor of EventBroker.
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: make(map[string]map[chan AuthEvent]struct{}),
+	}
+}
+
+// Subscribe subscribes to the events of the session. It returns a channel
+// receiving the events and a function to unsubscribe, which must be called
+// when the subscriber is no longer interested in the events. The channel is
+// closed when the broker is closed.
+func (b *EventBroker) Subscribe(sessionID string) (<-chan AuthEvent, func()) {
+	ch := make(chan AuthEvent, eventBufferSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if b.subscribers[sessionID] == nil {
+		b.subscribers[sessionID] = make(map[chan AuthEvent]struct{})
+	}
+	b.subscribers[sessionID][ch] = struct{}{}
+	b.active.Add(1)
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subscribers[sessionID], ch)
+			if len(b.subscribers[sessionID]) == 0 {
+				delete(b.subscribers, sessionID)
+			}
+			b.active.Done()
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Publish delivers the event to all subscribers of the session without
+// blocking.
+func (b *EventBroker) Publish(sessionID string, event AuthEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[sessionID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Close closes the channels of all subscribers so that they stop streaming
+// events, and waits until all of them have unsubscribed or the context is
+// done.
+func (b *EventBroker) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, channels := range b.subscribers {
+			for ch := range channels {
+				close(ch)
+			}
+		}
+		b.subscribers = make(map[string]map[chan AuthEvent]struct{})
+	}
+	b.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		b.active.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
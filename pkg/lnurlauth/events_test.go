@@ -0,0 +1,184 @@
+package lnurlauth_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestShutdownEndsLoginEventStreams(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	auth, err := lnurlauth.NewAuth(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := lnurlauth.NewHandler(auth)
+	mux.Handle(
+		"/login/events",
+		auth.HTTPMiddleware(http.HandlerFunc(handler.ServeLoginEvents)),
+	)
+
+	browser := newBrowser()
+	res, err := browser.Get(server.URL + "/login/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("events: status %d", res.StatusCode)
+	}
+
+	streamDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, res.Body)
+		streamDone <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := auth.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %s", err)
+	}
+
+	select {
+	case err := <-streamDone:
+		if err != nil {
+			t.Fatalf("stream: %s", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the stream to end on shutdown")
+	}
+
+	// Streams opened after the shutdown end right away.
+	res, err = browser.Get(server.URL + "/login/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		t.Fatalf("stream after shutdown: %s", err)
+	}
+}
+
+func TestLoginWebSocketSendsEvents(t *testing.T) {
+	server := newHTTPServer(
+		t,
+		lnurlauth.WithChallengeTTL(time.Millisecond*200),
+	)
+	w := lnurlauthtest.NewWallet(t)
+
+	// The verified event is sent once the wallet signs the challenge, and
+	// the connection is closed afterwards.
+	browser := newBrowser()
+	k1 := createChallenge(t, browser, server.URL)
+	conn := dialLoginWebSocket(t, browser, server.URL)
+	if err := w.Login(server.URL, k1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+	readAuthEvent(t, conn, lnurlauth.AuthEventVerified)
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(
+		err,
+		websocket.CloseNormalClosure,
+	) {
+		t.Fatalf("expected normal closure, got %v", err)
+	}
+
+	// The expired event is sent once the challenge expires.
+	browser = newBrowser()
+	createChallenge(t, browser, server.URL)
+	conn = dialLoginWebSocket(t, browser, server.URL)
+	readAuthEvent(t, conn, lnurlauth.AuthEventExpired)
+}
+
+func TestShutdownClosesLoginWebSockets(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	auth, err := lnurlauth.NewAuth(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := lnurlauth.NewHandler(auth)
+	mux.Handle(
+		"/login/ws",
+		auth.HTTPMiddleware(http.HandlerFunc(handler.ServeLoginWebSocket)),
+	)
+
+	conn := dialLoginWebSocket(t, newBrowser(), server.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := auth.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %s", err)
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(
+		err,
+		websocket.CloseGoingAway,
+	) {
+		t.Fatalf("expected the connection to close on shutdown, got %v", err)
+	}
+}
+
+// dialLoginWebSocket connects to the login WebSocket with the session cookie
+// of the browser.
+func dialLoginWebSocket(
+	t *testing.T,
+	browser *http.Client,
+	serverURL string,
+) *websocket.Conn {
+	t.Helper()
+
+	dialer := websocket.Dialer{Jar: browser.Jar}
+	conn, res, err := dialer.Dial(
+		"ws"+strings.TrimPrefix(serverURL, "http")+"/login/ws",
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	res.Body.Close()
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// readAuthEvent reads the events from the login WebSocket until the event of
+// the type arrives.
+func readAuthEvent(
+	t *testing.T,
+	conn *websocket.Conn,
+	eventType lnurlauth.AuthEventType,
+) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var event lnurlauth.AuthEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("expected %s event: %s", eventType, err)
+		}
+		if event.Type == eventType {
+			return
+		}
+	}
+}
@@ -0,0 +1,545 @@
+package lnurlauth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// flushInterval is the interval at which FileStore writes the changed data to
+// its file.
+const flushInterval = time.Second
+
+// FileStore is a persistent implementation of Store. It keeps all data in
+// memory and writes a snapshot of the data to a single JSON file at most once
+// per second when it has changed, so the sessions, the accounts and the
+// outstanding k1 challenges survive server restarts. The changes of the last
+// second are lost if the process crashes. Expired entries are swept
+// periodically, equivalent to the janitor of the in-memory caches.
+type FileStore struct {
+	// path is the location of the snapshot file.
+	path string
+
+	// mu guards data, dirty and accountSessions.
+	mu   sync.Mutex
+	data fileStoreData
+
+	// accountSessions is an index of the sessions in data by account. It is
+	// rebuilt when the snapshot is loaded.
+	accountSessions accountSessionIndex
+
+	// dirty reports whether data has changed since the latest snapshot.
+	dirty bool
+
+	// writeMu serializes the snapshot writes so that an older snapshot never
+	// replaces a newer one. The snapshot is written without holding mu.
+	writeMu sync.Mutex
+
+	// stop signals the background goroutine to exit.
+	stop chan struct{}
+
+	// closeOnce closes stop only once.
+	closeOnce sync.Once
+
+	// onSessionExpired is called with each expired session removed by the
+	// sweep. It is guarded by mu.
+	onSessionExpired func(session Session)
+}
+
+// fileStoreData is the content of the snapshot file.
+type fileStoreData struct {
+	// Sessions is a storage of mappings between session id and session.
+	// Sessions written by older versions have no account ID and are ignored.
+	Sessions map[string]Session `json:"sessions"`
+
+	// Accounts is a storage of mappings between account ID and account.
+	Accounts map[string]Account `json:"accounts"`
+
+	// Keys is a storage of mappings between linking key and the ID of the
+	// account that the key belongs to.
+	Keys map[string]fileStoreEntry `json:"keys"`
+
+	// Challenges is a storage of mappings between k1 challenge and session
+	// ID. The action of the challenge is kept in the entry.
+	Challenges map[string]fileStoreEntry `json:"challenges"`
+
+	// SessionChallenges is a reverse mapping of challenges, from session ID
+	// and action to k1 challenge.
+	SessionChallenges map[string]fileStoreEntry `json:"sessionChallenges"`
+
+	// ChallengeStatuses is a storage of mappings between k1 challenge and its
+	// status.
+	ChallengeStatuses map[string]fileStoreEntry `json:"challengeStatuses"`
+
+	// UsedChallenges is a storage of the k1 challenges that have been marked
+	// as used, so that a stateless challenge cannot be replayed after a
+	// restart.
+	UsedChallenges map[string]fileStoreEntry `json:"usedChallenges"`
+}
+
+// fileStoreEntry is a value stored in FileStore with its expiration time. An
+// entry with zero expiration time never expires.
+type fileStoreEntry struct {
+	Value     string    `json:"value"`
+	Action    Action    `json:"action,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// expired reports whether the entry has expired at the given time.
+func (e fileStoreEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// NewFileStore is a constructor of FileStore. It loads the previous snapshot
+// from the file at the given path if the file exists and starts writing the
+// changes and sweeping expired entries in the background. Close must be called
+// to write the final snapshot.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:            path,
+		accountSessions: make(accountSessionIndex),
+		stop:            make(chan struct{}),
+	}
+
+	dat, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(dat, &s.data); err != nil {
+			return nil, err
+		}
+	}
+
+	// Snapshots written by older versions may lack some of the maps.
+	if s.data.Sessions == nil {
+		s.data.Sessions = make(map[string]Session)
+	}
+	if s.data.Accounts == nil {
+		s.data.Accounts = make(map[string]Account)
+	}
+	for _, m := range []*map[string]fileStoreEntry{
+		&s.data.Keys,
+		&s.data.Challenges,
+		&s.data.SessionChallenges,
+		&s.data.ChallengeStatuses,
+		&s.data.UsedChallenges,
+	} {
+		if *m == nil {
+			*m = make(map[string]fileStoreEntry)
+		}
+	}
+
+	for _, session := range s.data.Sessions {
+		s.accountSessions.add(session)
+	}
+
+	// The entries that have expired while the server was down are dropped
+	// right away.
+	s.deleteExpired()
+
+	go s.run(flushInterval, cleanupInterval)
+
+	return s, nil
+}
+
+// Close stops the background goroutine and writes the final snapshot. It can
+// be called more than once. Changes made after Close are not written.
+func (s *FileStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+
+	return s.flush()
+}
+
+// IssueChallenge stores a mapping between k1 challenge and session ID in both
+// directions.
+func (s *FileStore) IssueChallenge(
+	k1 string,
+	sessionID string,
+	action Action,
+	ttl time.Duration,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.get(s.data.Challenges, k1); ok {
+		return errors.New("k1 challenge already exists")
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	s.data.Challenges[k1] = fileStoreEntry{
+		Value:     sessionID,
+		Action:    action,
+		ExpiresAt: expiresAt,
+	}
+	s.data.SessionChallenges[sessionChallengeKey(sessionID, action)] =
+		fileStoreEntry{
+			Value:     k1,
+			ExpiresAt: expiresAt,
+		}
+	s.dirty = true
+
+	return nil
+}
+
+// ChallengeBySession finds the k1 challenge of the session with the action.
+func (s *FileStore) ChallengeBySession(
+	sessionID string,
+	action Action,
+) (string, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionChallengeKey(sessionID, action)
+	k1, ok := s.get(s.data.SessionChallenges, key)
+	if !ok {
+		return "", time.Time{}, false
+	}
+
+	return k1, s.data.SessionChallenges[key].ExpiresAt, true
+}
+
+// SessionByChallenge finds the session ID and the action of the k1 challenge.
+func (s *FileStore) SessionByChallenge(k1 string) (string, Action, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessionID, ok := s.get(s.data.Challenges, k1)
+	if !ok {
+		return "", "", false
+	}
+
+	return sessionID, s.data.Challenges[k1].Action, true
+}
+
+// ConsumeChallenge deletes the k1 challenge in both directions. It returns
+// ErrChallengeNotFound if the challenge does not exist or has expired.
+func (s *FileStore) ConsumeChallenge(k1 string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.data.Challenges[k1]
+	if !ok || entry.expired(time.Now()) {
+		return ErrChallengeNotFound
+	}
+
+	delete(s.data.Challenges, k1)
+	delete(
+		s.data.SessionChallenges,
+		sessionChallengeKey(entry.Value, entry.Action),
+	)
+	s.dirty = true
+
+	return nil
+}
+
+// SetChallengeStatus sets the status of the k1 challenge.
+func (s *FileStore) SetChallengeStatus(
+	k1 string,
+	status ChallengeStatus,
+	ttl time.Duration,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.ChallengeStatuses[k1] = fileStoreEntry{
+		Value:     string(status),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	s.dirty = true
+
+	return nil
+}
+
+// ChallengeStatus finds the status of the k1 challenge.
+func (s *FileStore) ChallengeStatus(k1 string) (ChallengeStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.get(s.data.ChallengeStatuses, k1)
+	return ChallengeStatus(status), ok
+}
+
+// MarkChallengeUsed records the k1 challenge as used unless it already is.
+func (s *FileStore) MarkChallengeUsed(
+	k1 string,
+	ttl time.Duration,
+) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.get(s.data.UsedChallenges, k1); ok {
+		return false, nil
+	}
+
+	s.data.UsedChallenges[k1] = fileStoreEntry{ExpiresAt: time.Now().Add(ttl)}
+	s.dirty = true
+
+	return true, nil
+}
+
+// Session finds the session.
+func (s *FileStore) Session(sessionID string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.data.Sessions[sessionID]
+	if !ok || session.AccountID == "" || time.Now().After(session.ExpiresAt) {
+		return Session{}, false
+	}
+
+	return session, true
+}
+
+// SetSession sets the session.
+func (s *FileStore) SetSession(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if previous, ok := s.data.Sessions[session.ID]; ok {
+		s.accountSessions.remove(previous)
+	}
+	s.data.Sessions[session.ID] = session
+	s.accountSessions.add(session)
+	s.dirty = true
+
+	return nil
+}
+
+// DeleteSession removes the session ID.
+func (s *FileStore) DeleteSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.data.Sessions[sessionID]
+	if !ok {
+		return nil
+	}
+
+	delete(s.data.Sessions, sessionID)
+	s.accountSessions.remove(session)
+	s.dirty = true
+
+	return nil
+}
+
+// OnSessionExpired sets the function called with each expired session removed
+// by the background sweep.
+func (s *FileStore) OnSessionExpired(fn func(session Session)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onSessionExpired = fn
+}
+
+// Stats returns the numbers of entries held in memory.
+func (s *FileStore) Stats() StoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	activeSessions := 0
+	for _, session := range s.data.Sessions {
+		if session.AccountID != "" && !now.After(session.ExpiresAt) {
+			activeSessions++
+		}
+	}
+
+	return StoreStats{
+		ActiveSessions:    activeSessions,
+		Sessions:          len(s.data.Sessions),
+		Challenges:        len(s.data.Challenges),
+		SessionChallenges: len(s.data.SessionChallenges),
+	}
+}
+
+// SessionsByAccount finds the unexpired sessions of the account through the
+// index of the sessions by account.
+func (s *FileStore) SessionsByAccount(accountID string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var sessions []Session
+	for sessionID := range s.accountSessions[accountID] {
+		session := s.data.Sessions[sessionID]
+		if !now.After(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+// Account finds the account with the ID.
+func (s *FileStore) Account(accountID string) (Account, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.data.Accounts[accountID]
+	if !ok {
+		return Account{}, false
+	}
+
+	return cloneAccount(account), true
+}
+
+// AccountByKey finds the account that the linking key belongs to.
+func (s *FileStore) AccountByKey(linkingKey string) (Account, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accountID, ok := s.get(s.data.Keys, linkingKey)
+	if !ok {
+		return Account{}, false
+	}
+
+	account, ok := s.data.Accounts[accountID]
+	if !ok {
+		return Account{}, false
+	}
+
+	return cloneAccount(account), true
+}
+
+// SaveAccount sets the account and a mapping from each linking key of the
+// account to the account ID.
+func (s *FileStore) SaveAccount(account Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Remove the keys that have been removed from the account.
+	if previous, ok := s.data.Accounts[account.ID]; ok {
+		for _, linkingKey := range previous.LinkingKeys {
+			delete(s.data.Keys, linkingKey)
+		}
+	}
+
+	for _, linkingKey := range account.LinkingKeys {
+		s.data.Keys[linkingKey] = fileStoreEntry{Value: account.ID}
+	}
+	s.data.Accounts[account.ID] = cloneAccount(account)
+	s.dirty = true
+
+	return nil
+}
+
+// get returns the unexpired value of the key in the map. The caller must hold
+// the lock.
+func (s *FileStore) get(
+	m map[string]fileStoreEntry,
+	key string,
+) (string, bool) {
+	entry, ok := m[key]
+	if !ok || entry.expired(time.Now()) {
+		return "", false
+	}
+
+	return entry.Value, true
+}
+
+// run writes the changes at every flush interval and deletes expired entries
+// at every sweep interval until the store is closed.
+func (s *FileStore) run(flushInterval, sweepInterval time.Duration) {
+	flushTicker := time.NewTicker(flushInterval)
+	defer flushTicker.Stop()
+	sweepTicker := time.NewTicker(sweepInterval)
+	defer sweepTicker.Stop()
+
+	for {
+		select {
+		case <-flushTicker.C:
+			if err := s.flush(); err != nil {
+				log.Printf("file store: %s", err.Error())
+			}
+		case <-sweepTicker.C:
+			s.deleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// deleteExpired deletes all expired entries. The deleted sessions are written
+// with the next snapshot. The expired sessions are reported after the lock is
+// released.
+func (s *FileStore) deleteExpired() {
+	s.mu.Lock()
+
+	now := time.Now()
+	var expired []Session
+	for sessionID, session := range s.data.Sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.data.Sessions, sessionID)
+			s.accountSessions.remove(session)
+			expired = append(expired, session)
+			s.dirty = true
+		}
+	}
+	for _, m := range []map[string]fileStoreEntry{
+		s.data.Challenges,
+		s.data.SessionChallenges,
+		s.data.ChallengeStatuses,
+		s.data.UsedChallenges,
+	} {
+		for key, entry := range m {
+			if entry.expired(now) {
+				delete(m, key)
+				s.dirty = true
+			}
+		}
+	}
+
+	onSessionExpired := s.onSessionExpired
+	s.mu.Unlock()
+
+	if onSessionExpired != nil {
+		for _, session := range expired {
+			onSessionExpired(session)
+		}
+	}
+}
+
+// flush writes a snapshot of the data to the file if the data has changed
+// since the latest snapshot. The data is encoded under the lock, but the file
+// is written after releasing it so that the requests are not blocked on the
+// disk. The snapshot is written to a temporary file first and then renamed so
+// that the file is never left partially written.
+func (s *FileStore) flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	dat, err := json.Marshal(s.data)
+	if err == nil {
+		s.dirty = false
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	err = os.WriteFile(tmpPath, dat, 0o600)
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		// Try again with the next flush.
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package lnurlauth_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+)
+
+func TestFileStoreReloadsAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := lnurlauth.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	account := lnurlauth.Account{
+		ID:          "account",
+		LinkingKeys: []string{"key"},
+		CreatedAt:   now,
+	}
+	if err := store.SaveAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range []lnurlauth.Session{
+		{ID: "active", AccountID: account.ID, ExpiresAt: now.Add(time.Hour)},
+		{
+			ID:        "expiring",
+			AccountID: account.ID,
+			ExpiresAt: now.Add(100 * time.Millisecond),
+		},
+	} {
+		if err := store.SetSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.IssueChallenge(
+		"k1",
+		"active",
+		lnurlauth.ActionNone,
+		time.Hour,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetChallengeStatus(
+		"k1",
+		lnurlauth.ChallengeStatusPending,
+		time.Hour,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.IssueChallenge(
+		"expiring-k1",
+		"expiring",
+		lnurlauth.ActionNone,
+		100*time.Millisecond,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.MarkChallengeUsed("used-k1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot is written in the background without closing the store.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected snapshot to be written before closing")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Closing the store flushes the final snapshot. The expiring session has
+	// expired by now, since the background snapshot is written only after the
+	// flush interval.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close twice: %s", err)
+	}
+
+	// The snapshot has been renamed into place, and a temporary file left
+	// behind by a crash does not affect loading it.
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no temporary file, got %v", err)
+	}
+	if err := os.WriteFile(path+".tmp", []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = lnurlauth.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, ok := store.Session("active"); !ok {
+		t.Fatal("expected session to survive restart")
+	}
+	if _, ok := store.Session("expiring"); ok {
+		t.Fatal("expected session to expire across restart")
+	}
+	reloaded, ok := store.AccountByKey("key")
+	if !ok || reloaded.ID != account.ID {
+		t.Fatalf("expected account to survive restart, got %+v", reloaded)
+	}
+
+	// The outstanding challenges survive, so that a QR code shown before the
+	// restart can still be scanned.
+	if sessionID, _, ok := store.SessionByChallenge("k1"); !ok ||
+		sessionID != "active" {
+		t.Fatalf("expected challenge to survive restart, got %q", sessionID)
+	}
+	if k1, _, ok := store.ChallengeBySession(
+		"active",
+		lnurlauth.ActionNone,
+	); !ok || k1 != "k1" {
+		t.Fatalf("expected session challenge to survive restart, got %q", k1)
+	}
+	if status, ok := store.ChallengeStatus("k1"); !ok ||
+		status != lnurlauth.ChallengeStatusPending {
+		t.Fatalf("expected pending status to survive restart, got %q", status)
+	}
+	if _, _, ok := store.SessionByChallenge("expiring-k1"); ok {
+		t.Fatal("expected challenge to expire across restart")
+	}
+	if marked, err := store.MarkChallengeUsed(
+		"used-k1",
+		time.Hour,
+	); err != nil || marked {
+		t.Fatalf("expected used challenge to survive restart, got %v", err)
+	}
+
+	// The next snapshot replaces the temporary file.
+	if err := store.DeleteSession("active"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no temporary file, got %v", err)
+	}
+	store, err = lnurlauth.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, ok := store.Session("active"); ok {
+		t.Fatal("expected deleted session not to survive restart")
+	}
+}
@@ -0,0 +1,245 @@
+package lnurlauth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunboyy/lnurlauth/pkg"
+)
+
+// Handler contains all handlers for LNURL-auth authentication. Each handler is
+// available as a net/http handler (prefixed with `Serve`) and, except for
+// Home, as a Gin handler which is a thin adapter over the net/http one.
+type Handler struct {
+	auth *Auth
+}
+
+// NewHandler is a constructor of Handler.
+func NewHandler(auth *Auth) *Handler {
+	return &Handler{
+		auth: auth,
+	}
+}
+
+// Home is a Gin handler for the index page. It has two conditions to show the
+// page. If the user is not signed in, it will show the sign in page. The
+// challenge is not created until the sign in page requests it through
+// CreateChallenge, so that visitors never showing the QR code, e.g. crawlers,
+// do not create challenges. Otherwise, it will display the page with signed in
+// account information. The application must provide the HTML templates
+// `login.tmpl`, rendered with an empty AuthChallenge, and `index.tmpl`,
+// rendered with the field `Account`.
+func (h *Handler) Home(c *gin.Context) {
+	// Get session id from the request context.
+	sessionID, ok := sessionIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	account, ok := h.auth.Account(sessionID)
+	if !ok {
+		c.HTML(http.StatusOK, "login.tmpl", AuthChallenge{})
+		return
+	}
+
+	c.HTML(http.StatusOK, "index.tmpl", gin.H{
+		"Account": account,
+	})
+}
+
+// LinkWallet is a Gin handler for the page linking another Lightning wallet
+// application to the account of the signed-in user. It shows a challenge with
+// the `link` action so that the linking key signing it is added to the
+// account. If the user is not signed in, it will redirect the user to the
+// index page. The application must provide the HTML template `link.tmpl`,
+// rendered with AuthChallenge.
+func (h *Handler) LinkWallet(c *gin.Context) {
+	// Get session id from the request context.
+	sessionID, ok := sessionIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	if _, ok := h.auth.AccountID(sessionID); !ok {
+		c.Redirect(http.StatusSeeOther, "/")
+		return
+	}
+
+	authChallenge, err := h.auth.challenge(
+		sessionID,
+		ActionLink,
+		h.auth.newClientInfo(c.Request),
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.HTML(http.StatusOK, "link.tmpl", authChallenge)
+}
+
+// SessionsPage is a Gin handler for the page listing the active sessions of
+// the account of the signed-in user. If the user is not signed in, it will
+// redirect the user to the index page. The application must provide the HTML
+// template `sessions.tmpl`, rendered with the field `Sessions` containing the
+// list of SessionInfo.
+func (h *Handler) SessionsPage(c *gin.Context) {
+	// Get session id from the request context.
+	sessionID, ok := sessionIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.auth.Sessions(sessionID)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/")
+		return
+	}
+
+	c.HTML(http.StatusOK, "sessions.tmpl", gin.H{
+		"Sessions": sessions,
+	})
+}
+
+// CreateChallenge is a Gin handler of the JSON API for front-end applications
+// to obtain a challenge for the current session. It responds with the
+// challenge containing the LNURL, the QR code image, the k1 challenge and its
+// expiration time. The optional query param `action` sets the LUD-04 action
+// of the challenge, which is one of `register`, `login`, `link` and `auth`. If
+// the user is not signed in, every challenge comes with a new session cookie,
+// so the login events must be subscribed to after receiving the challenge.
+func (h *Handler) CreateChallenge(c *gin.Context) {
+	h.ServeChallenge(c.Writer, c.Request)
+}
+
+// ChallengeStatus is a Gin handler of the JSON API responding with the status
+// of the k1 challenge given in the path parameter `k1`. The status is one of
+// `pending`, `verified` and `expired`. Unknown challenges get HTTP status 404.
+func (h *Handler) ChallengeStatus(c *gin.Context) {
+	h.writeChallengeStatus(c.Writer, c.Param("k1"))
+}
+
+// LoginEvents is a Gin handler streaming the authentication events of the
+// current session as Server-Sent Events. The login page listens to the stream
+// so that it can proceed as soon as the user is signed in. If the session is
+// already signed in, the `verified` event is sent immediately.
+func (h *Handler) LoginEvents(c *gin.Context) {
+	h.ServeLoginEvents(c.Writer, c.Request)
+}
+
+// LoginWebSocket is a Gin handler streaming the authentication events of the
+// current session through a WebSocket connection. It is an alternative to
+// LoginEvents for networks that buffer Server-Sent Events. Each event is sent
+// as a JSON text message. The connection is closed after the `verified`
+// event.
+func (h *Handler) LoginWebSocket(c *gin.Context) {
+	h.ServeLoginWebSocket(c.Writer, c.Request)
+}
+
+// Login is a Gin handler to handle the request with signed k1 challenge from
+// Lightning wallet application according to the LUD-04 RFC. The following query
+// params must be set:
+//   - tag: fixed value "login"
+//   - k1: the random challenge previously generated by the system
+//   - key: the identity of the user as public key (linking key)
+//   - sig: the signature that verifies the identity of the user
+//
+// In stateless mode, the query param `state` generated together with the k1
+// challenge must also be set.
+func (h *Handler) Login(c *gin.Context) {
+	h.ServeLogin(c.Writer, c.Request)
+}
+
+// CreateToken is a Gin handler of the JSON API exchanging the signed-in
+// session for a JWT access token and a refresh token, for API clients that
+// authenticate with bearer tokens instead of the session cookie. Bearer tokens
+// must be enabled with WithAccessTokens.
+func (h *Handler) CreateToken(c *gin.Context) {
+	h.ServeToken(c.Writer, c.Request)
+}
+
+// RefreshToken is a Gin handler of the JSON API issuing new tokens in exchange
+// for the refresh token given in the form param `refresh_token`. The refresh
+// token is only accepted while its session is signed in.
+func (h *Handler) RefreshToken(c *gin.Context) {
+	h.ServeRefreshToken(c.Writer, c.Request)
+}
+
+// Sessions is a Gin handler of the JSON API listing the active sessions of the
+// account of the signed-in user with their creation time, last seen time, user
+// agent and IP address.
+func (h *Handler) Sessions(c *gin.Context) {
+	h.ServeSessions(c.Writer, c.Request)
+}
+
+// RevokeSession is a Gin handler of the JSON API logging out the session with
+// the public ID given in the path parameter `id`. The session must belong to
+// the account of the signed-in user.
+func (h *Handler) RevokeSession(c *gin.Context) {
+	h.revokeSession(c.Writer, c.Request, c.Param("id"))
+}
+
+// LogoutEverywhere is a Gin handler of the JSON API logging out all sessions
+// of the account of the signed-in user, including the current session.
+func (h *Handler) LogoutEverywhere(c *gin.Context) {
+	h.ServeLogoutEverywhere(c.Writer, c.Request)
+}
+
+// UpdateProfile is a Gin handler for the signed-in user to edit the profile of
+// the account. It reads the profile fields from the form param `name` and then
+// redirects the user to the index page.
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	h.ServeUpdateProfile(c.Writer, c.Request)
+}
+
+// UnlinkKey is a Gin handler for the signed-in user to remove the linking key
+// given in the form param `key` from the account. The last linking key of the
+// account cannot be removed. It redirects the user to the index page.
+func (h *Handler) UnlinkKey(c *gin.Context) {
+	h.ServeUnlinkKey(c.Writer, c.Request)
+}
+
+// Logout is a Gin handler for logging the user out. It logs the user out from
+// the authentication service, removes session ID from the request cookie and
+// then redirects the user to the index page.
+func (h *Handler) Logout(c *gin.Context) {
+	h.ServeLogout(c.Writer, c.Request)
+}
+
+// sessionIDFromContext gets the session ID set by the authentication
+// middleware from the request context. If the session ID is missing, it
+// responds with an internal server error and returns false in the second
+// return value.
+func sessionIDFromContext(c *gin.Context) (string, bool) {
+	sessionIDIntf, ok := c.Get(SessionIDContextKey)
+	if !ok {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "unexpected a request context with no session id"},
+		)
+		return "", false
+	}
+
+	sessionID, ok := sessionIDIntf.(string)
+	if !ok {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "unexpected session id with invalid type"},
+		)
+		return "", false
+	}
+
+	return sessionID, true
+}
+
+// createErrorResponse creates a struct of response body that comply to the
+// LNURL-auth standard in the case that authentication fails or an error occurs.
+func createErrorResponse(reason string) pkg.LNURLAuthResponse {
+	return pkg.LNURLAuthResponse{
+		Status: pkg.LNURLAuthResponseStatusError,
+		Reason: reason,
+	}
+}
@@ -0,0 +1,587 @@
+package lnurlauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sunboyy/lnurlauth/pkg"
+)
+
+// contextKey is a type of the keys of values that the authentication
+// middleware sets to context.Context. It prevents collisions with keys defined
+// in other packages.
+type contextKey string
+
+const (
+	sessionIDKey contextKey = SessionIDContextKey
+	accountIDKey contextKey = AccountIDContextKey
+)
+
+// keepAliveInterval is the interval at which a comment line is sent to an
+// idle event stream, or a ping message is sent to an idle WebSocket.
+const keepAliveInterval = time.Second * 15
+
+// maxProfileNameLength is the maximum length in bytes of the display name of
+// an account.
+const maxProfileNameLength = 64
+
+// upgrader upgrades HTTP connections to WebSocket connections. Only requests
+// from the same origin are accepted.
+var upgrader = websocket.Upgrader{}
+
+// SessionIDFromContext returns the session ID set by the authentication
+// middleware. If the middleware has not been run, it will return false in the
+// second return value.
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionIDKey).(string)
+	return sessionID, ok
+}
+
+// AccountIDFromContext returns the ID of the signed-in account set by the
+// authentication middleware. If the user is not signed in, it will return
+// false in the second return value.
+func AccountIDFromContext(ctx context.Context) (string, bool) {
+	accountID, ok := ctx.Value(accountIDKey).(string)
+	return accountID, ok
+}
+
+// newSessionContext returns a copy of the context carrying the session ID and,
+// if it is not empty, the account ID.
+func newSessionContext(
+	ctx context.Context,
+	sessionID string,
+	accountID string,
+) context.Context {
+	ctx = context.WithValue(ctx, sessionIDKey, sessionID)
+	if accountID != "" {
+		ctx = context.WithValue(ctx, accountIDKey, accountID)
+	}
+	return ctx
+}
+
+// HTTPMiddleware is the net/http equivalent of Middleware. It retrieves the
+// session ID cookie from the request, or generates a new session ID if the
+// cookie does not exist, and passes the session ID and the account ID (if the
+// user is signed in) to the next handler through the request context. Use
+// SessionIDFromContext and AccountIDFromContext to read them. Like Middleware,
+// a bearer access token takes precedence over the cookie.
+func (a *Auth) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Authenticate with the bearer token if the request has one.
+		sessionID, accountID, ok, err := a.sessionFromBearerToken(r)
+		if ok {
+			if err != nil {
+				writeJSON(
+					w,
+					http.StatusUnauthorized,
+					map[string]string{"error": "invalid access token"},
+				)
+				return
+			}
+
+			ctx := newSessionContext(r.Context(), sessionID, accountID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		// Get session ID from the cookie. If the request doesn't include
+		// session ID cookie, create and set a new session ID.
+		sessionID, ok = a.sessionCookie(r)
+		if !ok {
+			sessionID = random32BytesHex()
+			a.setSessionCookie(w, sessionID)
+		}
+
+		// Try to retrieve account ID. It is empty if the user is not signed
+		// in.
+		accountID, _ = a.authenticateSession(r, sessionID)
+
+		ctx := newSessionContext(r.Context(), sessionID, accountID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ServeChallenge is the net/http equivalent of CreateChallenge. The request
+// must have passed through the authentication middleware.
+func (h *Handler) ServeChallenge(w http.ResponseWriter, r *http.Request) {
+	if _, ok := sessionIDFromRequest(w, r); !ok {
+		return
+	}
+
+	action := Action(r.URL.Query().Get("action"))
+	if !action.Valid() {
+		writeJSON(
+			w,
+			http.StatusBadRequest,
+			map[string]string{"error": "invalid action"},
+		)
+		return
+	}
+
+	authChallenge, err := h.auth.RequestChallenge(w, r, action)
+	if err != nil {
+		writeJSON(
+			w,
+			http.StatusInternalServerError,
+			map[string]string{"error": err.Error()},
+		)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, authChallenge)
+}
+
+// ServeChallengeStatus is the net/http equivalent of ChallengeStatus. Since
+// net/http has no path parameters, the k1 challenge is read from the query
+// parameter `k1`.
+func (h *Handler) ServeChallengeStatus(w http.ResponseWriter, r *http.Request) {
+	h.writeChallengeStatus(w, r.URL.Query().Get("k1"))
+}
+
+// writeChallengeStatus responds with the status of the k1 challenge, or with
+// HTTP status 404 if the challenge is unknown.
+func (h *Handler) writeChallengeStatus(w http.ResponseWriter, k1 string) {
+	status, ok := h.auth.ChallengeStatus(k1)
+	if !ok {
+		writeJSON(
+			w,
+			http.StatusNotFound,
+			map[string]string{"error": errChallengeUnknown.Error()},
+		)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"k1":     k1,
+		"status": string(status),
+	})
+}
+
+// ServeLoginEvents is the net/http equivalent of LoginEvents. The request
+// must have passed through the authentication middleware.
+func (h *Handler) ServeLoginEvents(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := sessionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(
+			w,
+			http.StatusInternalServerError,
+			map[string]string{"error": "streaming is not supported"},
+		)
+		return
+	}
+
+	// Subscribe before checking the session so that the event cannot be missed
+	// in between.
+	events, unsubscribe := h.auth.Subscribe(sessionID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if _, ok := h.auth.AccountID(sessionID); ok {
+		_ = writeServerSentEvent(w, AuthEvent{Type: AuthEventVerified})
+		flusher.Flush()
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The server is shutting down. The browser reconnects once
+				// the stream ends.
+				return
+			}
+			if err := writeServerSentEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+
+			if event.Type == AuthEventVerified {
+				return
+			}
+		case <-keepAlive.C:
+			// Send a comment line to keep the connection open through
+			// proxies.
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ServeLoginWebSocket is the net/http equivalent of LoginWebSocket. The
+// request must have passed through the authentication middleware.
+func (h *Handler) ServeLoginWebSocket(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := sessionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded with an error.
+		return
+	}
+	defer conn.Close()
+
+	// Subscribe before checking the session so that the event cannot be missed
+	// in between.
+	events, unsubscribe := h.auth.Subscribe(sessionID)
+	defer unsubscribe()
+
+	if _, ok := h.auth.AccountID(sessionID); ok {
+		_ = conn.WriteJSON(AuthEvent{Type: AuthEventVerified})
+		return
+	}
+
+	// Read from the connection to process control messages and detect when
+	// the client goes away. Messages from the client are discarded.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The server is shutting down.
+				_ = conn.WriteMessage(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(
+						websocket.CloseGoingAway,
+						"",
+					),
+				)
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+			if event.Type == AuthEventVerified {
+				_ = conn.WriteMessage(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(
+						websocket.CloseNormalClosure,
+						"",
+					),
+				)
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteMessage(
+				websocket.PingMessage,
+				nil,
+			); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// ServeLogin is the net/http equivalent of Login. It handles the request with
+// signed k1 challenge from Lightning wallet application. It does not require
+// the authentication middleware.
+func (h *Handler) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// From the RFC (https://github.com/fiatjaf/lnurl-rfc/blob/luds/04.md), the
+	// request must have the query `tag` to `login`.
+	if query.Get("tag") != "login" {
+		writeJSON(
+			w,
+			http.StatusBadRequest,
+			createErrorResponse("query parameter `tag` is not 'login'"),
+		)
+		return
+	}
+
+	k1 := query.Get("k1")
+	linkingKey := query.Get("key")
+	signature := query.Get("sig")
+	state := query.Get("state")
+
+	// Perform login using the provided information in the query parameters.
+	if err := h.auth.login(
+		k1,
+		linkingKey,
+		signature,
+		state,
+		h.auth.newClientInfo(r),
+	); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errTooManyRequests) {
+			status = http.StatusTooManyRequests
+		}
+		writeJSON(w, status, createErrorResponse(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pkg.LNURLAuthResponse{
+		Status: pkg.LNURLAuthResponseStatusOK,
+	})
+}
+
+// ServeToken is the net/http equivalent of CreateToken. The request must have
+// passed through the authentication middleware.
+func (h *Handler) ServeToken(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := sessionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.auth.IssueTokens(sessionID)
+	if err != nil {
+		writeJSON(
+			w,
+			http.StatusUnauthorized,
+			map[string]string{"error": err.Error()},
+		)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// ServeRefreshToken is the net/http equivalent of RefreshToken. It does not
+// require the authentication middleware.
+func (h *Handler) ServeRefreshToken(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.auth.RefreshTokens(r, r.PostFormValue("refresh_token"))
+	if err != nil {
+		writeJSON(
+			w,
+			http.StatusUnauthorized,
+			map[string]string{"error": err.Error()},
+		)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// ServeSessions is the net/http equivalent of Sessions. The request must have
+// passed through the authentication middleware.
+func (h *Handler) ServeSessions(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := sessionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.auth.Sessions(sessionID)
+	if err != nil {
+		writeJSON(
+			w,
+			http.StatusUnauthorized,
+			map[string]string{"error": err.Error()},
+		)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// ServeRevokeSession is the net/http equivalent of RevokeSession. Since
+// net/http has no path parameters, the session is read from the query
+// parameter `id`. The request must have passed through the authentication
+// middleware.
+func (h *Handler) ServeRevokeSession(w http.ResponseWriter, r *http.Request) {
+	h.revokeSession(w, r, r.URL.Query().Get("id"))
+}
+
+// revokeSession logs out the session with the public ID.
+func (h *Handler) revokeSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	publicID string,
+) {
+	sessionID, ok := sessionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.RevokeSession(sessionID, publicID); err != nil {
+		writeJSON(
+			w,
+			http.StatusBadRequest,
+			map[string]string{"error": err.Error()},
+		)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// ServeLogoutEverywhere is the net/http equivalent of LogoutEverywhere. The
+// request must have passed through the authentication middleware.
+func (h *Handler) ServeLogoutEverywhere(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	sessionID, ok := sessionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.LogoutEverywhere(sessionID); err != nil {
+		writeJSON(
+			w,
+			http.StatusBadRequest,
+			map[string]string{"error": err.Error()},
+		)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// ServeUpdateProfile is the net/http equivalent of UpdateProfile. The request
+// must have passed through the authentication middleware.
+func (h *Handler) ServeUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := sessionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	profile := Profile{
+		Name: strings.TrimSpace(r.PostFormValue("name")),
+	}
+	if len(profile.Name) > maxProfileNameLength {
+		writeJSON(
+			w,
+			http.StatusBadRequest,
+			map[string]string{"error": "name is too long"},
+		)
+		return
+	}
+
+	if err := h.auth.UpdateProfile(sessionID, profile); err != nil {
+		writeJSON(
+			w,
+			http.StatusUnauthorized,
+			map[string]string{"error": err.Error()},
+		)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ServeUnlinkKey is the net/http equivalent of UnlinkKey. The request must
+// have passed through the authentication middleware.
+func (h *Handler) ServeUnlinkKey(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := sessionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.UnlinkKey(
+		sessionID,
+		r.PostFormValue("key"),
+	); err != nil {
+		writeJSON(
+			w,
+			http.StatusBadRequest,
+			map[string]string{"error": err.Error()},
+		)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ServeLogout is the net/http equivalent of Logout. The request must have
+// passed through the authentication middleware.
+func (h *Handler) ServeLogout(w http.ResponseWriter, r *http.Request) {
+	// Always redirect to home screen.
+	defer http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+
+	sessionID, ok := SessionIDFromContext(r.Context())
+	if !ok {
+		return
+	}
+
+	// Remove session ID from the authentication service.
+	if err := h.auth.Logout(sessionID); err != nil {
+		log.Printf("logout: %s", err.Error())
+	}
+
+	// Unset session ID cookie.
+	h.auth.clearSessionCookie(w)
+}
+
+// sessionIDFromRequest gets the session ID set by the authentication
+// middleware from the request context. If the session ID is missing, it
+// responds with an internal server error and returns false in the second
+// return value.
+func sessionIDFromRequest(
+	w http.ResponseWriter,
+	r *http.Request,
+) (string, bool) {
+	sessionID, ok := SessionIDFromContext(r.Context())
+	if !ok {
+		writeJSON(
+			w,
+			http.StatusInternalServerError,
+			map[string]string{
+				"error": "unexpected a request context with no session id",
+			},
+		)
+		return "", false
+	}
+
+	return sessionID, true
+}
+
+// writeJSON responds with the value encoded in JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeServerSentEvent writes the event in the Server-Sent Events format. The
+// event name is the type of the event and the data is the event in JSON.
+func writeServerSentEvent(w io.Writer, event AuthEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
@@ -0,0 +1,98 @@
+// Package lnurlauthtest provides utilities for testing servers built on the
+// lnurlauth package, such as a wallet signing k1 challenges.
+package lnurlauthtest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+// Wallet is a stand-in of a Lightning wallet application signing k1
+// challenges with a single linking key.
+type Wallet struct {
+	privateKey *btcec.PrivateKey
+}
+
+// NewWallet creates a wallet with a new linking key.
+func NewWallet(t testing.TB) Wallet {
+	t.Helper()
+
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Wallet{privateKey: privateKey}
+}
+
+// LinkingKey returns the linking key of the wallet in hex.
+func (w Wallet) LinkingKey() string {
+	return hex.EncodeToString(w.privateKey.PubKey().SerializeCompressed())
+}
+
+// Sign signs the k1 challenge in hex and returns the DER-encoded signature in
+// hex.
+func (w Wallet) Sign(k1 string) (string, error) {
+	k1Bytes, err := hex.DecodeString(k1)
+	if err != nil {
+		return "", err
+	}
+
+	signature := btcecdsa.Sign(w.privateKey, k1Bytes)
+	return hex.EncodeToString(signature.Serialize()), nil
+}
+
+// Login signs the k1 challenge and calls back the login endpoint, which is
+// served at `/login` of the server.
+func (w Wallet) Login(serverURL string, k1 string) error {
+	return w.Callback(serverURL + "/login?" + url.Values{
+		"tag": {"login"},
+		"k1":  {k1},
+	}.Encode())
+}
+
+// Callback signs the k1 challenge in the login URL and calls the URL with the
+// signature and the linking key, keeping the other parameters, such as the
+// action and the challenge state, unchanged. A LUD-04 `ERROR` response is
+// returned as an error with the reason.
+func (w Wallet) Callback(loginURL string) error {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+
+	signature, err := w.Sign(query.Get("k1"))
+	if err != nil {
+		return err
+	}
+
+	query.Set("sig", signature)
+	query.Set("key", w.LinkingKey())
+	u.RawQuery = query.Encode()
+
+	res, err := http.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var data struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return err
+	}
+	if data.Status != "OK" {
+		return errors.New(data.Reason)
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package lnurlauth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// cleanupInterval is the interval at which expired entries are purged from
+// the in-memory caches.
+const cleanupInterval = time.Minute * 10
+
+// MemoryStore is an in-memory implementation of Store. All data is lost when
+// the server restarts, so it is suitable for a single server instance only.
+type MemoryStore struct {
+	// sessionCache is a storage of mappings between session id and session.
+	sessionCache *cache.Cache
+
+	// sessionMu guards accountSessions and onSessionExpired, and serializes
+	// the updates of sessionCache so that they stay consistent with
+	// accountSessions.
+	sessionMu sync.Mutex
+
+	// accountSessions is an index of the sessions in sessionCache by
+	// account.
+	accountSessions accountSessionIndex
+
+	// onSessionExpired is called with each expired session purged from
+	// sessionCache.
+	onSessionExpired func(session Session)
+
+	// challengeMu serializes the updates of challengeCache and
+	// reverseChallengeCache so that a k1 challenge is consumed only once.
+	challengeMu sync.Mutex
+
+	// challengeCache is a storage of the randomized k1 challenge. Only the
+	// k1 stored in this cache can be used to login.
+	challengeCache *cache.Cache
+
+	// reverseChallengeCache is a reverse mapping of challengeCache. Instead of
+	// storing mappings from k1 challenge to session ID, this variable stores
+	// mappings from session ID to k1 challenge.
+	reverseChallengeCache *cache.Cache
+
+	// challengeStatusCache is a storage of mappings between k1 challenge and
+	// its status.
+	challengeStatusCache *cache.Cache
+
+	// usedChallengeCache is a storage of the k1 challenges that have been
+	// marked as used.
+	usedChallengeCache *cache.Cache
+
+	// accountMu serializes the updates of accountCache and keyCache so that
+	// they stay consistent with each other.
+	accountMu sync.Mutex
+
+	// accountCache is a storage of mappings between account ID and account.
+	accountCache *cache.Cache
+
+	// keyCache is a storage of mappings between linking key and the ID of the
+	// account that the key belongs to.
+	keyCache *cache.Cache
+}
+
+// memoryChallenge is a k1 challenge stored in the challenge cache.
+type memoryChallenge struct {
+	sessionID string
+	action    Action
+}
+
+// NewMemoryStore is a constructor of MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		sessionCache: cache.New(
+			cache.NoExpiration,
+			cleanupInterval,
+		),
+		accountSessions: make(accountSessionIndex),
+		challengeCache: cache.New(
+			cache.NoExpiration,
+			cleanupInterval,
+		),
+		reverseChallengeCache: cache.New(
+			cache.NoExpiration,
+			cleanupInterval,
+		),
+		challengeStatusCache: cache.New(
+			cache.NoExpiration,
+			cleanupInterval,
+		),
+		usedChallengeCache: cache.New(
+			cache.NoExpiration,
+			cleanupInterval,
+		),
+		accountCache: cache.New(
+			cache.NoExpiration,
+			cleanupInterval,
+		),
+		keyCache: cache.New(
+			cache.NoExpiration,
+			cleanupInterval,
+		),
+	}
+	s.sessionCache.OnEvicted(s.sessionEvicted)
+
+	return s
+}
+
+// IssueChallenge stores a mapping between k1 challenge and session ID to the
+// challenge caches.
+func (s *MemoryStore) IssueChallenge(
+	k1 string,
+	sessionID string,
+	action Action,
+	ttl time.Duration,
+) error {
+	s.challengeMu.Lock()
+	defer s.challengeMu.Unlock()
+
+	if err := s.challengeCache.Add(
+		k1,
+		memoryChallenge{sessionID: sessionID, action: action},
+		ttl,
+	); err != nil {
+		return err
+	}
+	return s.reverseChallengeCache.Add(
+		sessionChallengeKey(sessionID, action),
+		k1,
+		ttl,
+	)
+}
+
+// ChallengeBySession finds the k1 challenge of the session in the reverse
+// challenge cache.
+func (s *MemoryStore) ChallengeBySession(
+	sessionID string,
+	action Action,
+) (string, time.Time, bool) {
+	k1Intf, expiresAt, ok := s.reverseChallengeCache.GetWithExpiration(
+		sessionChallengeKey(sessionID, action),
+	)
+	if !ok {
+		return "", time.Time{}, false
+	}
+
+	k1, ok := k1Intf.(string)
+	if !ok {
+		return "", time.Time{}, false
+	}
+
+	return k1, expiresAt, true
+}
+
+// SessionByChallenge finds the session ID and the action of the k1 challenge
+// in the challenge cache.
+func (s *MemoryStore) SessionByChallenge(k1 string) (string, Action, bool) {
+	challenge, ok := s.challenge(k1)
+	if !ok {
+		return "", "", false
+	}
+
+	return challenge.sessionID, challenge.action, true
+}
+
+// ConsumeChallenge deletes the k1 challenge from both challenge caches. It
+// returns ErrChallengeNotFound if the challenge is not in the challenge cache.
+func (s *MemoryStore) ConsumeChallenge(k1 string) error {
+	s.challengeMu.Lock()
+	defer s.challengeMu.Unlock()
+
+	challenge, ok := s.challenge(k1)
+	if !ok {
+		return ErrChallengeNotFound
+	}
+
+	s.reverseChallengeCache.Delete(
+		sessionChallengeKey(challenge.sessionID, challenge.action),
+	)
+	s.challengeCache.Delete(k1)
+	return nil
+}
+
+// challenge retrieves the k1 challenge from the challenge cache.
+func (s *MemoryStore) challenge(k1 string) (memoryChallenge, bool) {
+	challengeIntf, ok := s.challengeCache.Get(k1)
+	if !ok {
+		return memoryChallenge{}, false
+	}
+
+	challenge, ok := challengeIntf.(memoryChallenge)
+	return challenge, ok
+}
+
+// SetChallengeStatus sets the status of the k1 challenge to the challenge
+// status cache.
+func (s *MemoryStore) SetChallengeStatus(
+	k1 string,
+	status ChallengeStatus,
+	ttl time.Duration,
+) error {
+	s.challengeStatusCache.Set(k1, status, ttl)
+	return nil
+}
+
+// ChallengeStatus finds the status of the k1 challenge in the challenge status
+// cache.
+func (s *MemoryStore) ChallengeStatus(k1 string) (ChallengeStatus, bool) {
+	statusIntf, ok := s.challengeStatusCache.Get(k1)
+	if !ok {
+		return "", false
+	}
+
+	status, ok := statusIntf.(ChallengeStatus)
+	if !ok {
+		return "", false
+	}
+
+	return status, true
+}
+
+// MarkChallengeUsed adds the k1 challenge to the used challenge cache unless
+// it is already there.
+func (s *MemoryStore) MarkChallengeUsed(
+	k1 string,
+	ttl time.Duration,
+) (bool, error) {
+	if err := s.usedChallengeCache.Add(k1, struct{}{}, ttl); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Session finds the session in the session cache.
+func (s *MemoryStore) Session(sessionID string) (Session, bool) {
+	sessionIntf, ok := s.sessionCache.Get(sessionID)
+	if !ok {
+		return Session{}, false
+	}
+
+	session, ok := sessionIntf.(Session)
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return Session{}, false
+	}
+
+	return session, true
+}
+
+// SetSession sets the session to the session cache until it expires and
+// indexes it by its account.
+func (s *MemoryStore) SetSession(session Session) error {
+	// The cache keeps entries with a non-positive TTL forever, so a session
+	// that has already expired is removed instead. The removal is reported to
+	// sessionEvicted, which takes the lock.
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		s.sessionCache.Delete(session.ID)
+		return nil
+	}
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	if previous, ok := s.Session(session.ID); ok &&
+		previous.AccountID != session.AccountID {
+		s.accountSessions.remove(previous)
+	}
+	s.sessionCache.Set(session.ID, session, ttl)
+	s.accountSessions.add(session)
+
+	return nil
+}
+
+// DeleteSession removes the session ID from the session cache. The removal is
+// reported to sessionEvicted, which removes the session from the index.
+func (s *MemoryStore) DeleteSession(sessionID string) error {
+	s.sessionCache.Delete(sessionID)
+	return nil
+}
+
+// OnSessionExpired sets the function called when an expired session is
+// purged from the session cache.
+func (s *MemoryStore) OnSessionExpired(fn func(session Session)) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	s.onSessionExpired = fn
+}
+
+// sessionEvicted removes the session deleted from or purged by the session
+// cache from the index. The cache reports both, which are told apart by the
+// expiration time of the session, and only the expired sessions are passed to
+// onSessionExpired.
+func (s *MemoryStore) sessionEvicted(sessionID string, value interface{}) {
+	session, ok := value.(Session)
+	if !ok {
+		return
+	}
+
+	s.sessionMu.Lock()
+	// The cache reports the eviction after releasing its lock, so the session
+	// may have been set again in between.
+	if _, ok := s.sessionCache.Get(sessionID); !ok {
+		s.accountSessions.remove(session)
+	}
+	onSessionExpired := s.onSessionExpired
+	s.sessionMu.Unlock()
+
+	if onSessionExpired != nil && !session.ExpiresAt.After(time.Now()) {
+		onSessionExpired(session)
+	}
+}
+
+// Stats returns the sizes of the session cache and the challenge caches. The
+// active sessions are counted by scanning the session cache.
+func (s *MemoryStore) Stats() StoreStats {
+	return StoreStats{
+		ActiveSessions:    len(s.sessionCache.Items()),
+		Sessions:          s.sessionCache.ItemCount(),
+		Challenges:        s.challengeCache.ItemCount(),
+		SessionChallenges: s.reverseChallengeCache.ItemCount(),
+	}
+}
+
+// SessionsByAccount finds the unexpired sessions of the account through the
+// index of the sessions by account.
+func (s *MemoryStore) SessionsByAccount(accountID string) ([]Session, error) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	var sessions []Session
+	for sessionID := range s.accountSessions[accountID] {
+		session, ok := s.Session(sessionID)
+		if !ok || session.AccountID != accountID {
+			// The session has expired, or its ID has been signed in to
+			// another account after it expired.
+			s.accountSessions.remove(Session{
+				ID:        sessionID,
+				AccountID: accountID,
+			})
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// Account finds the account in the account cache.
+func (s *MemoryStore) Account(accountID string) (Account, bool) {
+	accountIntf, ok := s.accountCache.Get(accountID)
+	if !ok {
+		return Account{}, false
+	}
+
+	account, ok := accountIntf.(Account)
+	if !ok {
+		return Account{}, false
+	}
+
+	return cloneAccount(account), true
+}
+
+// AccountByKey finds the account ID of the linking key in the key cache and
+// then finds the account in the account cache.
+func (s *MemoryStore) AccountByKey(linkingKey string) (Account, bool) {
+	accountID, ok := getString(s.keyCache, linkingKey)
+	if !ok {
+		return Account{}, false
+	}
+
+	return s.Account(accountID)
+}
+
+// SaveAccount sets the account to the account cache and sets a mapping from
+// each linking key of the account to the account ID to the key cache.
+func (s *MemoryStore) SaveAccount(account Account) error {
+	s.accountMu.Lock()
+	defer s.accountMu.Unlock()
+
+	// Remove the keys that have been removed from the account.
+	if previous, ok := s.Account(account.ID); ok {
+		for _, linkingKey := range previous.LinkingKeys {
+			s.keyCache.Delete(linkingKey)
+		}
+	}
+
+	for _, linkingKey := range account.LinkingKeys {
+		s.keyCache.Set(linkingKey, account.ID, cache.NoExpiration)
+	}
+	s.accountCache.Set(account.ID, cloneAccount(account), cache.NoExpiration)
+
+	return nil
+}
+
+// getString retrieves a string value from the cache. If the key does not
+// exist or the value is not a string, it will return false in the second
+// return value.
+func getString(c *cache.Cache, key string) (string, bool) {
+	valueIntf, ok := c.Get(key)
+	if !ok {
+		return "", false
+	}
+
+	value, ok := valueIntf.(string)
+	if !ok {
+		return "", false
+	}
+
+	return value, true
+}
@@ -0,0 +1,42 @@
+package lnurlauth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+)
+
+func TestMemoryStoreDropsExpiredSessions(t *testing.T) {
+	store := lnurlauth.NewMemoryStore()
+	for _, session := range []lnurlauth.Session{
+		{
+			ID:        "expired",
+			AccountID: "account",
+			ExpiresAt: time.Now().Add(-time.Second),
+		},
+		{
+			ID:        "expiring",
+			AccountID: "account",
+			ExpiresAt: time.Now().Add(50 * time.Millisecond),
+		},
+	} {
+		if err := store.SetSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := store.Session("expired"); ok {
+		t.Fatal("expected session stored after expiry to be dropped")
+	}
+	if _, ok := store.Session("expiring"); !ok {
+		t.Fatal("expected unexpired session")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := store.Session("expiring"); ok {
+		t.Fatal("expected session to expire")
+	}
+	if stats := store.Stats(); stats.ActiveSessions != 0 {
+		t.Fatalf("expected no active sessions, got %+v", stats)
+	}
+}
@@ -0,0 +1,264 @@
+package lnurlauth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsNamespace is the prefix of the names of the metrics.
+const metricsNamespace = "lnurlauth"
+
+// loginFailureReasons map the errors of failed logins to the values of the
+// `reason` label. Other errors are reported as `other`, since their messages
+// may contain input of the client and would make the number of label values
+// unbounded.
+var loginFailureReasons = []struct {
+	err    error
+	reason string
+}{
+	{errChallengeExpired, "challenge_expired"},
+	{errChallengeUsed, "challenge_used"},
+	{errChallengeUnknown, "challenge_unknown"},
+	{errInvalidState, "invalid_state"},
+	{errTooManyRequests, "rate_limited"},
+	{errInvalidSignature, "invalid_signature"},
+	{errKeyRegistered, "key_registered"},
+	{errKeyNotRegistered, "key_not_registered"},
+	{errKeyOfAnotherAccount, "key_of_another_account"},
+	{errNotSignedIn, "not_signed_in"},
+}
+
+// loginDurationBuckets are the buckets of the histogram of the time from
+// issuing a k1 challenge to logging in with it, which is mostly spent by the
+// user scanning the QR code.
+var loginDurationBuckets = []float64{1, 2, 5, 10, 20, 30, 60, 120, 300}
+
+// metrics are the Prometheus metrics of Auth. A nil metrics records nothing.
+type metrics struct {
+	// challengesIssued counts the issued k1 challenges by action.
+	challengesIssued *prometheus.CounterVec
+
+	// loginsSucceeded counts the successful logins by action.
+	loginsSucceeded *prometheus.CounterVec
+
+	// loginsFailed counts the failed logins by reason.
+	loginsFailed *prometheus.CounterVec
+
+	// logouts counts the logouts of signed-in sessions.
+	logouts prometheus.Counter
+
+	// loginDuration observes the time from issuing a k1 challenge to logging
+	// in with it.
+	loginDuration prometheus.Histogram
+}
+
+// newMetrics is a constructor of metrics. The metrics, together with the
+// statistics of the challenges and the store of the Auth, are registered to
+// the registerer.
+func newMetrics(
+	registerer prometheus.Registerer,
+	auth *Auth,
+) (*metrics, error) {
+	m := &metrics{
+		challengesIssued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "challenges_issued_total",
+			Help:      "Number of k1 challenges issued, by action.",
+		}, []string{"action"}),
+		loginsSucceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logins_succeeded_total",
+			Help:      "Number of successful logins, by action.",
+		}, []string{"action"}),
+		loginsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logins_failed_total",
+			Help:      "Number of failed logins, by reason.",
+		}, []string{"reason"}),
+		logouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logouts_total",
+			Help:      "Number of logouts of signed-in sessions.",
+		}),
+		loginDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "login_duration_seconds",
+			Help: "Time from issuing a k1 challenge to logging in " +
+				"with it.",
+			Buckets: loginDurationBuckets,
+		}),
+	}
+
+	for _, collector := range []prometheus.Collector{
+		m.challengesIssued,
+		m.loginsSucceeded,
+		m.loginsFailed,
+		m.logouts,
+		m.loginDuration,
+		newStatsCollector(auth),
+	} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// challengeIssued counts the newly issued k1 challenge.
+func (m *metrics) challengeIssued(action Action) {
+	if m == nil {
+		return
+	}
+	m.challengesIssued.WithLabelValues(actionLabel(action)).Inc()
+}
+
+// loginSucceeded counts the successful login and observes the time since the
+// k1 challenge was issued. A zero issue time is not observed.
+func (m *metrics) loginSucceeded(action Action, issuedAt time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.loginsSucceeded.WithLabelValues(actionLabel(action)).Inc()
+	if !issuedAt.IsZero() {
+		m.loginDuration.Observe(time.Since(issuedAt).Seconds())
+	}
+}
+
+// loginFailed counts the failed login by the reason of the error.
+func (m *metrics) loginFailed(err error) {
+	if m == nil {
+		return
+	}
+	m.loginsFailed.WithLabelValues(loginFailureReason(err)).Inc()
+}
+
+// loggedOut counts the logout of a signed-in session.
+func (m *metrics) loggedOut() {
+	if m == nil {
+		return
+	}
+	m.logouts.Inc()
+}
+
+// actionLabel returns the value of the `action` label of the action.
+func actionLabel(action Action) string {
+	if action == ActionNone {
+		return "none"
+	}
+	return string(action)
+}
+
+// loginFailureReason returns the value of the `reason` label of the error.
+func loginFailureReason(err error) string {
+	for _, r := range loginFailureReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "other"
+}
+
+// statsCollector collects the statistics of the outstanding k1 challenges
+// and, if the store implements StoreStatsReporter, the entries of the store
+// when the metrics are scraped.
+type statsCollector struct {
+	auth *Auth
+
+	challengesOutstanding *prometheus.Desc
+	challengesEvicted     *prometheus.Desc
+	sessionsActive        *prometheus.Desc
+	storeEntries          *prometheus.Desc
+}
+
+// newStatsCollector is a constructor of statsCollector.
+func newStatsCollector(auth *Auth) *statsCollector {
+	return &statsCollector{
+		auth: auth,
+		challengesOutstanding: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				metricsNamespace,
+				"",
+				"challenges_outstanding",
+			),
+			"Number of k1 challenges issued by this server that have neither "+
+				"been used nor expired.",
+			nil,
+			nil,
+		),
+		challengesEvicted: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				metricsNamespace,
+				"",
+				"challenges_evicted_total",
+			),
+			"Number of k1 challenges evicted because there were too many "+
+				"outstanding challenges.",
+			nil,
+			nil,
+		),
+		sessionsActive: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "sessions_active"),
+			"Number of signed-in sessions that have not expired.",
+			nil,
+			nil,
+		),
+		storeEntries: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "store_entries"),
+			"Number of entries held by the store, including expired "+
+				"entries that have not been purged yet, by cache.",
+			[]string{"cache"},
+			nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of the statistics.
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.challengesOutstanding
+	ch <- c.challengesEvicted
+	ch <- c.sessionsActive
+	ch <- c.storeEntries
+}
+
+// Collect reads the statistics and sends them as metrics.
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	challengeStats := c.auth.ChallengeStats()
+	ch <- prometheus.MustNewConstMetric(
+		c.challengesOutstanding,
+		prometheus.GaugeValue,
+		float64(challengeStats.Outstanding),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.challengesEvicted,
+		prometheus.CounterValue,
+		float64(challengeStats.Evicted),
+	)
+
+	reporter, ok := c.auth.store.(StoreStatsReporter)
+	if !ok {
+		return
+	}
+
+	storeStats := reporter.Stats()
+	ch <- prometheus.MustNewConstMetric(
+		c.sessionsActive,
+		prometheus.GaugeValue,
+		float64(storeStats.ActiveSessions),
+	)
+	for cache, entries := range map[string]int{
+		"sessions":           storeStats.Sessions,
+		"challenges":         storeStats.Challenges,
+		"session_challenges": storeStats.SessionChallenges,
+	} {
+		ch <- prometheus.MustNewConstMetric(
+			c.storeEntries,
+			prometheus.GaugeValue,
+			float64(entries),
+			cache,
+		)
+	}
+}
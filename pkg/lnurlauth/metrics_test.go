@@ -0,0 +1,108 @@
+package lnurlauth_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestMetricsCountLoginCycle(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	server := newHTTPServer(t, lnurlauth.WithMetrics(registry))
+	browser := newBrowser()
+
+	k1 := createChallenge(t, browser, server.URL)
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Login(server.URL, strings.Repeat("ab", 32)); err == nil {
+		t.Fatal("expected login with unknown challenge to fail")
+	}
+	if err := w.Login(server.URL, k1); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+
+	expected := map[string]float64{
+		`lnurlauth_challenges_issued_total{action="none"}`:          1,
+		`lnurlauth_logins_failed_total{reason="challenge_unknown"}`: 1,
+		`lnurlauth_logins_succeeded_total{action="none"}`:           1,
+		`lnurlauth_login_duration_seconds_count`:                    1,
+		`lnurlauth_sessions_active`:                                 1,
+		`lnurlauth_store_entries{cache="challenges"}`:               0,
+		`lnurlauth_challenges_outstanding`:                          0,
+	}
+	for name, value := range expected {
+		if actual := gatherMetric(t, registry, name); actual != value {
+			t.Fatalf("expected %s to be %v, got %v", name, value, actual)
+		}
+	}
+
+	res, err := browser.Get(server.URL + "/logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if actual := gatherMetric(
+		t,
+		registry,
+		"lnurlauth_logouts_total",
+	); actual != 1 {
+		t.Fatalf("expected one logout, got %v", actual)
+	}
+	if actual := gatherMetric(
+		t,
+		registry,
+		"lnurlauth_sessions_active",
+	); actual != 0 {
+		t.Fatalf("expected no active session, got %v", actual)
+	}
+}
+
+// gatherMetric returns the value of the metric, written as the metric name
+// followed by its labels in the Prometheus text format. The value of a
+// histogram is its sample count, selected with the `_count` suffix.
+func gatherMetric(
+	t *testing.T,
+	registry *prometheus.Registry,
+	name string,
+) float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(
+					labels,
+					fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()),
+				)
+			}
+
+			id := family.GetName()
+			if len(labels) > 0 {
+				id += "{" + strings.Join(labels, ",") + "}"
+			}
+
+			switch {
+			case metric.Counter != nil && id == name:
+				return metric.GetCounter().GetValue()
+			case metric.Gauge != nil && id == name:
+				return metric.GetGauge().GetValue()
+			case metric.Histogram != nil && id+"_count" == name:
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	t.Fatalf("metric %s not found", name)
+	return 0
+}
@@ -0,0 +1,228 @@
+package lnurlauth
+
+import "time"
+
+// AuthChallenge contains the challenge data used for autentication.
+type AuthChallenge struct {
+	// LNURL is an authentication URL that is compatible with Bitcoin Lightning
+	// Wallet applications. It is a bech32-encoded URL with "lightning:"
+	// protocol scheme.
+	LNURL string `json:"lnurl"`
+
+	// QRCodeURL is a URL of the QR code image which represents the previously
+	// described LNURL. It creates more convinence to the user as the user can
+	// scan the QR code in this image instead of copying the LNURL.
+	QRCodeURL string `json:"qrcodeUrl"`
+
+	// K1 is the k1 challenge embedded in the LNURL. It can be used to query
+	// the status of the challenge.
+	K1 string `json:"k1"`
+
+	// ExpiresAt is the time after which the challenge can no longer be used
+	// to login.
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// Action is the action of the challenge. It is empty if the action is
+	// unspecified.
+	Action Action `json:"action,omitempty"`
+}
+
+// Account is an account of a user. A user can log in to the same account with
+// any of the linking keys (public keys) of the account, e.g. from multiple
+// Lightning wallet applications.
+type Account struct {
+	// ID is the unique identifier of the account.
+	ID string `json:"id"`
+
+	// Profile contains the profile fields of the account.
+	Profile Profile `json:"profile"`
+
+	// LinkingKeys are the linking keys that can be used to log in to the
+	// account. An account has at least one linking key.
+	LinkingKeys []string `json:"linkingKeys"`
+
+	// CreatedAt is the time at which the account was registered.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Profile contains the profile fields of an account that the user can edit.
+type Profile struct {
+	// Name is the display name of the user. It is empty if the user has not
+	// set it.
+	Name string `json:"name"`
+}
+
+// Session is a session signed in to an account.
+type Session struct {
+	// ID is the session ID, which is the value of the session cookie. It must
+	// be kept secret, so it is never exposed through SessionInfo.
+	ID string `json:"id"`
+
+	// AccountID is the ID of the account that the session is signed in to.
+	AccountID string `json:"accountId"`
+
+	// LinkingKey is the linking key that the session signed in with.
+	LinkingKey string `json:"linkingKey,omitempty"`
+
+	// CreatedAt is the time at which the session was signed in.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// LastSeenAt is the time of the latest request of the session. It is
+	// updated at most once a minute.
+	LastSeenAt time.Time `json:"lastSeenAt"`
+
+	// UserAgent is the user agent of the latest request of the session.
+	UserAgent string `json:"userAgent"`
+
+	// IP is the IP address of the latest request of the session.
+	IP string `json:"ip"`
+
+	// ExpiresAt is the time after which the session is signed out. It is the
+	// earlier of the idle timeout after the latest request and the absolute
+	// timeout after signing in.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ChallengeStats are statistics of the outstanding k1 challenges kept in the
+// store.
+type ChallengeStats struct {
+	// Outstanding is the number of challenges that have been issued but have
+	// neither been used nor expired.
+	Outstanding int `json:"outstanding"`
+
+	// Evicted is the number of challenges evicted before they expired because
+	// there were too many outstanding challenges.
+	Evicted uint64 `json:"evicted"`
+}
+
+// StoreStats are the numbers of entries held by a store. The entry counts
+// include expired entries that have not been purged yet.
+type StoreStats struct {
+	// ActiveSessions is the number of signed-in sessions that have not
+	// expired.
+	ActiveSessions int `json:"activeSessions"`
+
+	// Sessions is the number of session entries.
+	Sessions int `json:"sessions"`
+
+	// Challenges is the number of k1 challenge entries.
+	Challenges int `json:"challenges"`
+
+	// SessionChallenges is the number of entries mapping a session and an
+	// action to a k1 challenge.
+	SessionChallenges int `json:"sessionChallenges"`
+}
+
+// SessionInfo describes a session of an account to the user, e.g. on the
+// sessions page.
+type SessionInfo struct {
+	// ID is a public identifier of the session derived from the session ID.
+	// It is used to revoke the session without revealing the session ID.
+	ID string `json:"id"`
+
+	// Current indicates that this is the session making the request.
+	Current bool `json:"current"`
+
+	// CreatedAt is the time at which the session was signed in.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// LastSeenAt is the time of the latest request of the session.
+	LastSeenAt time.Time `json:"lastSeenAt"`
+
+	// UserAgent is the user agent of the latest request of the session.
+	UserAgent string `json:"userAgent"`
+
+	// IP is the IP address of the latest request of the session.
+	IP string `json:"ip"`
+}
+
+// Action is the `action` parameter of LUD-04 stating the purpose of the k1
+// challenge. The wallet application may display it to the user before signing.
+type Action string
+
+const (
+	// ActionNone is an unspecified action. The user is registered if the
+	// linking key is unknown and is logged in otherwise.
+	ActionNone Action = ""
+
+	// ActionRegister registers a new user. It fails if the linking key is
+	// already known.
+	ActionRegister Action = "register"
+
+	// ActionLogin logs an existing user in. It fails if the linking key is
+	// unknown.
+	ActionLogin Action = "login"
+
+	// ActionLink links the linking key to the user signed in to the session.
+	// The session must already be signed in.
+	ActionLink Action = "link"
+
+	// ActionAuth authorizes a stated action. The signature is verified without
+	// changing the session.
+	ActionAuth Action = "auth"
+)
+
+// Valid reports whether the action is one of the actions defined in LUD-04 or
+// is unspecified.
+func (a Action) Valid() bool {
+	switch a {
+	case ActionNone, ActionRegister, ActionLogin, ActionLink, ActionAuth:
+		return true
+	default:
+		return false
+	}
+}
+
+// ChallengeStatus is a status of a k1 challenge.
+type ChallengeStatus string
+
+const (
+	// ChallengeStatusPending indicates that the challenge is waiting for the
+	// Lightning wallet application to sign.
+	ChallengeStatusPending ChallengeStatus = "pending"
+
+	// ChallengeStatusVerified indicates that the challenge has been signed and
+	// the session is signed in.
+	ChallengeStatusVerified ChallengeStatus = "verified"
+
+	// ChallengeStatusExpired indicates that the challenge has expired or is
+	// unknown to the server.
+	ChallengeStatusExpired ChallengeStatus = "expired"
+)
+
+// AuthEventType is a type of authentication event of a session.
+type AuthEventType string
+
+const (
+	// AuthEventIssued indicates that a new k1 challenge has been issued for
+	// the session.
+	AuthEventIssued AuthEventType = "issued"
+
+	// AuthEventCallback indicates that the Lightning wallet application has
+	// called back with a signature of the k1 challenge, i.e. the QR code has
+	// been scanned.
+	AuthEventCallback AuthEventType = "callback"
+
+	// AuthEventVerified indicates that the signature of the k1 challenge has
+	// been verified and the session is signed in.
+	AuthEventVerified AuthEventType = "verified"
+
+	// AuthEventExpired indicates that the k1 challenge has expired before the
+	// session is signed in.
+	AuthEventExpired AuthEventType = "expired"
+
+	// AuthEventFailed indicates that the login with the k1 challenge has
+	// failed. The reason is given in the event.
+	AuthEventFailed AuthEventType = "failed"
+)
+
+// AuthEvent is an authentication event of a session delivered to the
+// subscribers of the session.
+type AuthEvent struct {
+	// Type is the type of the event.
+	Type AuthEventType `json:"type"`
+
+	// Reason describes why the login has failed. It is only set for the
+	// `failed` event.
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,189 @@
+package lnurlauth
+
+import (
+	"crypto"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Option is a functional option configuring Auth in NewAuth.
+type Option func(*options)
+
+// options contains the configurations of Auth.
+type options struct {
+	// store is a storage of sessions and challenges.
+	store Store
+
+	// challengeSecret is the secret for stateless k1 challenges. If it is nil,
+	// k1 challenges are kept in the store.
+	challengeSecret []byte
+
+	// challengeTTL is the duration in which a k1 challenge can be used after
+	// issued.
+	challengeTTL time.Duration
+
+	// maxChallenges is the maximum number of outstanding k1 challenges kept
+	// in the store.
+	maxChallenges int
+
+	// rateLimits are the rate limits of the requests. If it is nil, the
+	// default rate limits are used.
+	rateLimits *RateLimits
+
+	// tokenKey is the private key signing bearer tokens. If it is nil, bearer
+	// tokens are disabled.
+	tokenKey crypto.Signer
+
+	// tokenTTL is the lifetime of access tokens.
+	tokenTTL time.Duration
+
+	// cookie contains the attributes of the session cookie. If it is nil, the
+	// attributes are derived from the hostname.
+	cookie *CookieConfig
+
+	// trustedProxies are the IP addresses and CIDR ranges of the reverse
+	// proxies whose forwarding headers are trusted.
+	trustedProxies []string
+
+	// idleTimeout is the duration of inactivity after which a session is
+	// signed out.
+	idleTimeout time.Duration
+
+	// absoluteTimeout is the duration after signing in after which a session
+	// is signed out regardless of activity.
+	absoluteTimeout time.Duration
+
+	// auditSink receives the audit events.
+	auditSink AuditSink
+
+	// metricsRegisterer is the registerer of the Prometheus metrics.
+	metricsRegisterer prometheus.Registerer
+
+	// webhooks deliver the login and logout notifications.
+	webhooks []*WebhookNotifier
+}
+
+// WithStore sets the storage of sessions and challenges. An in-memory store is
+// used by default, in which all sessions are lost when the server restarts.
+func WithStore(store Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithStatelessChallenges switches the k1 challenges to stateless mode. Instead
+// of storing random k1 challenges in the store, the k1 challenges are
+// authenticated tokens derived from the secret, so any server sharing the same
+// secret and the same session store can accept the login. This allows running
+// several servers behind a load balancer. The used challenges are recorded in
+// the store to prevent replays, so the store must be shared by all servers,
+// and MarkChallengeUsed must be atomic across them.
+func WithStatelessChallenges(secret []byte) Option {
+	return func(o *options) {
+		o.challengeSecret = secret
+	}
+}
+
+// WithChallengeTTL sets the duration in which a k1 challenge can be used after
+// it is issued. A new challenge is issued to the session once the previous one
+// expires. The default is five minutes.
+func WithChallengeTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.challengeTTL = ttl
+	}
+}
+
+// WithMaxChallenges sets the maximum number of outstanding k1 challenges that
+// the server tracks. Once exceeded, the least recently used challenges are
+// evicted and reported as expired. The default is 10000. The limit also
+// applies in stateless mode, in which each challenge still holds a timer and
+// a status until it expires.
+func WithMaxChallenges(max int) Option {
+	return func(o *options) {
+		o.maxChallenges = max
+	}
+}
+
+// WithRateLimits sets the rate limits of the login callbacks, the failed
+// signatures and the challenge creation. By default, each IP address can make
+// 30 login callbacks and 60 requests creating challenges per minute, and each
+// k1 challenge can fail signature verification 5 times per minute. A zero
+// RateLimit disables the corresponding limit.
+func WithRateLimits(limits RateLimits) Option {
+	return func(o *options) {
+		o.rateLimits = &limits
+	}
+}
+
+// WithAccessTokens enables bearer tokens. A signed-in session can be exchanged
+// for a JWT access token, valid for the given time-to-live, and a refresh
+// token. The key must be either a P-256 ECDSA key (ES256) or an Ed25519 key
+// (EdDSA). The authentication middleware then accepts the access token in the
+// `Authorization` header in place of the session cookie.
+func WithAccessTokens(key crypto.Signer, ttl time.Duration) Option {
+	return func(o *options) {
+		o.tokenKey = key
+		o.tokenTTL = ttl
+	}
+}
+
+// WithCookie sets the attributes of the session cookie. By default, the cookie
+// is named `lnurl_sess`, is sent to the host that set it on all paths, uses
+// SameSite=Lax and is secure if the hostname is an HTTPS URL.
+func WithCookie(cookie CookieConfig) Option {
+	return func(o *options) {
+		o.cookie = &cookie
+	}
+}
+
+// WithTrustedProxies sets the IP addresses and CIDR ranges (e.g. 10.0.0.0/8)
+// of the reverse proxies in front of the server. The IP address of a client,
+// which is rate limited and recorded in the sessions and the audit log, is
+// then read from the `X-Forwarded-For` or `X-Real-IP` header of the requests
+// coming from these proxies. By default, no proxy is trusted and the address
+// of the peer is used.
+func WithTrustedProxies(proxies ...string) Option {
+	return func(o *options) {
+		o.trustedProxies = append(o.trustedProxies, proxies...)
+	}
+}
+
+// WithSessionTimeouts sets the timeouts of the sessions. A session is signed
+// out once it has been inactive for the idle timeout, or once the absolute
+// timeout has passed since signing in, whichever comes first. By default, the
+// idle timeout is one hour and the absolute timeout is 24 hours.
+func WithSessionTimeouts(idle time.Duration, absolute time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = idle
+		o.absoluteTimeout = absolute
+	}
+}
+
+// WithAuditSink records the authentication events, e.g. the issued challenges,
+// the logins and the logouts, to the audit sink. If the store implements
+// SessionExpiryNotifier, the expired sessions are recorded as well.
+func WithAuditSink(sink AuditSink) Option {
+	return func(o *options) {
+		o.auditSink = sink
+	}
+}
+
+// WithMetrics registers the Prometheus metrics of the authentication, such as
+// the issued challenges, the logins by outcome, the logouts and the time taken
+// to log in, to the registerer. If the store implements StoreStatsReporter,
+// the active sessions and the sizes of the store are reported as well.
+func WithMetrics(registerer prometheus.Registerer) Option {
+	return func(o *options) {
+		o.metricsRegisterer = registerer
+	}
+}
+
+// WithWebhook sends a notification to the webhook notifier on every login and
+// logout. It can be given more than once to notify several endpoints. The
+// notifier is owned by the caller, which must close it on shutdown.
+func WithWebhook(notifier *WebhookNotifier) Option {
+	return func(o *options) {
+		o.webhooks = append(o.webhooks, notifier)
+	}
+}
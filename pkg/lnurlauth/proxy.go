@@ -0,0 +1,98 @@
+package lnurlauth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the networks of the reverse proxies whose forwarding
+// headers are trusted. A nil trustedProxies trusts no proxy.
+type trustedProxies []*net.IPNet
+
+// newTrustedProxies parses the IP addresses and CIDR ranges of the trusted
+// proxies.
+func newTrustedProxies(proxies []string) (trustedProxies, error) {
+	var networks trustedProxies
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%s'", proxy)
+			}
+			bits := net.IPv6len * 8
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = net.IPv4len * 8
+			}
+			proxy = fmt.Sprintf("%s/%d", ip, bits)
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s'", proxy)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// trusts reports whether the IP address belongs to a trusted proxy.
+func (p trustedProxies) trusts(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client making the request. If the
+// request comes from a trusted proxy, the address is taken from the
+// `X-Forwarded-For` header, skipping the trusted proxies from the right since
+// the entries on the left can be forged by the client, or else from the
+// `X-Real-IP` header. Headers of other peers are ignored.
+func (p trustedProxies) clientIP(r *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(remoteIP)
+	if ip == nil || !p.trusts(ip) {
+		return remoteIP
+	}
+
+	forwardedFor := r.Header.Values("X-Forwarded-For")
+	if len(forwardedFor) > 0 {
+		hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				// A malformed entry cannot be followed any further.
+				break
+			}
+			ip = hop
+			if !p.trusts(hop) {
+				break
+			}
+		}
+		return ip.String()
+	}
+
+	if realIP := net.ParseIP(
+		strings.TrimSpace(r.Header.Get("X-Real-IP")),
+	); realIP != nil {
+		return realIP.String()
+	}
+
+	return remoteIP
+}
+
+// clientIP returns the IP address of the client making the request, taking
+// the trusted proxies into account.
+func (a *Auth) clientIP(r *http.Request) string {
+	return a.proxies.clientIP(r)
+}
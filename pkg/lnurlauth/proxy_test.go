@@ -0,0 +1,97 @@
+package lnurlauth_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+)
+
+func TestForwardedIPsAreTrustedFromTrustedProxies(t *testing.T) {
+	limits := lnurlauth.WithRateLimits(lnurlauth.RateLimits{
+		Challenge: lnurlauth.RateLimit{Limit: 1, Period: time.Minute},
+	})
+
+	// requestChallenge requests a challenge with the forwarding header and
+	// returns the status code.
+	requestChallenge := func(
+		serverURL string,
+		header string,
+		value string,
+	) int {
+		req, err := http.NewRequest(
+			http.MethodPost,
+			serverURL+"/api/challenge",
+			nil,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(header, value)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// The test server is reached through the loopback address, which is
+	// trusted as a proxy.
+	server := newHTTPServer(
+		t,
+		limits,
+		lnurlauth.WithTrustedProxies("127.0.0.0/8", "::1"),
+	)
+	for _, step := range []struct {
+		header string
+		value  string
+		status int
+	}{
+		{"X-Forwarded-For", "203.0.113.1", http.StatusOK},
+		{"X-Forwarded-For", "203.0.113.1", http.StatusTooManyRequests},
+		{"X-Forwarded-For", "203.0.113.2", http.StatusOK},
+		// Trusted proxies are skipped from the right.
+		{"X-Forwarded-For", "203.0.113.2, ::1", http.StatusTooManyRequests},
+		// Entries left of the client can be forged and are ignored.
+		{"X-Forwarded-For", "203.0.113.2, 203.0.113.3", http.StatusOK},
+		{"X-Real-IP", "203.0.113.4", http.StatusOK},
+		{"X-Real-IP", "203.0.113.4", http.StatusTooManyRequests},
+	} {
+		status := requestChallenge(server.URL, step.header, step.value)
+		if status != step.status {
+			t.Fatalf(
+				"expected %d for %s %q, got %d",
+				step.status,
+				step.header,
+				step.value,
+				status,
+			)
+		}
+	}
+
+	// Without trusted proxies, the headers are ignored.
+	server = newHTTPServer(t, limits)
+	if status := requestChallenge(
+		server.URL,
+		"X-Forwarded-For",
+		"203.0.113.1",
+	); status != http.StatusOK {
+		t.Fatalf("expected first challenge, got %d", status)
+	}
+	if status := requestChallenge(
+		server.URL,
+		"X-Forwarded-For",
+		"203.0.113.2",
+	); status != http.StatusTooManyRequests {
+		t.Fatalf("expected forged header to be ignored, got %d", status)
+	}
+
+	if _, err := lnurlauth.NewAuth(
+		"http://example.com",
+		lnurlauth.WithTrustedProxies("10.0.0.0/33"),
+	); err == nil {
+		t.Fatal("expected invalid trusted proxy to be rejected")
+	}
+}
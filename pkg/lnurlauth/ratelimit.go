@@ -0,0 +1,201 @@
+package lnurlauth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/time/rate"
+)
+
+// errTooManyRequests is the LUD-04 error reason when a rate limit is
+// exceeded.
+var errTooManyRequests = errors.New("too many requests, try again later")
+
+// RateLimit is a token-bucket rate limit allowing up to Limit requests per
+// Period. The bucket starts full, so Limit requests can be made in a burst,
+// and refills evenly over the period. A zero Limit disables the rate limit.
+type RateLimit struct {
+	// Limit is the number of requests allowed per period.
+	Limit int
+
+	// Period is the duration in which the bucket refills completely.
+	Period time.Duration
+}
+
+// RateLimits are the rate limits of the requests that cost the server work
+// without proving that the client owns a linking key.
+type RateLimits struct {
+	// Callback limits the login callbacks of wallet applications per IP
+	// address.
+	Callback RateLimit
+
+	// FailedSignature limits the failed signature verifications per k1
+	// challenge. Once exceeded, the k1 challenge is rejected without
+	// verifying the signature.
+	FailedSignature RateLimit
+
+	// Challenge limits the requests creating k1 challenges per IP address.
+	Challenge RateLimit
+}
+
+// defaultRateLimits are the rate limits used when WithRateLimits is not given.
+var defaultRateLimits = RateLimits{
+	Callback:        RateLimit{Limit: 30, Period: time.Minute},
+	FailedSignature: RateLimit{Limit: 5, Period: time.Minute},
+	Challenge:       RateLimit{Limit: 60, Period: time.Minute},
+}
+
+// rateLimiter keeps a token bucket for each key, e.g. an IP address or a k1
+// challenge. A nil rateLimiter allows everything.
+type rateLimiter struct {
+	// limit is the rate limit of each bucket.
+	limit RateLimit
+
+	// mu serializes the creation of buckets so that concurrent requests with
+	// the same key share one bucket.
+	mu sync.Mutex
+
+	// buckets is a storage of mappings between key and bucket. A bucket is
+	// removed once it has been unused for the whole period, by which time it
+	// would have refilled completely anyway.
+	buckets *cache.Cache
+}
+
+// newRateLimiter is a constructor of rateLimiter. It returns nil if the rate
+// limit is disabled.
+func newRateLimiter(limit RateLimit) (*rateLimiter, error) {
+	if limit.Limit == 0 {
+		return nil, nil
+	}
+	if limit.Limit < 0 || limit.Period <= 0 {
+		return nil, errors.New("rate limit and period must be positive")
+	}
+
+	return &rateLimiter{
+		limit:   limit,
+		buckets: cache.New(limit.Period, cleanupInterval),
+	}, nil
+}
+
+// bucket returns the bucket of the key, creating a full one if the key has
+// no bucket. The expiration of the bucket is extended.
+func (l *rateLimiter) bucket(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets.Get(key)
+	if !ok {
+		bucket = rate.NewLimiter(
+			rate.Every(l.limit.Period/time.Duration(l.limit.Limit)),
+			l.limit.Limit,
+		)
+	}
+	l.buckets.SetDefault(key, bucket)
+
+	return bucket.(*rate.Limiter)
+}
+
+// allow takes a token from the bucket of the key. It reports whether the
+// bucket had a token.
+func (l *rateLimiter) allow(key string) bool {
+	if l == nil {
+		return true
+	}
+	return l.bucket(key).Allow()
+}
+
+// exhausted reports whether the bucket of the key has no token left, without
+// taking one.
+func (l *rateLimiter) exhausted(key string) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mu.Lock()
+	bucket, ok := l.buckets.Get(key)
+	l.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	return bucket.(*rate.Limiter).Tokens() < 1
+}
+
+// LimitCallbacks is a Gin middleware limiting the login callbacks of wallet
+// applications per IP address. It should be placed in front of Login.
+// Requests over the limit are rejected with a LUD-04 `ERROR` response.
+func (a *Auth) LimitCallbacks(c *gin.Context) {
+	if !a.callbackLimiter.allow(a.clientIP(c.Request)) {
+		writeTooManyRequests(c.Writer, a.limits.Callback)
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// HTTPLimitCallbacks is the net/http equivalent of LimitCallbacks.
+func (a *Auth) HTTPLimitCallbacks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.callbackLimiter.allow(a.clientIP(r)) {
+			writeTooManyRequests(w, a.limits.Callback)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LimitChallenges is a Gin middleware limiting the requests creating k1
+// challenges, e.g. the index page and the challenge API, per IP address. It
+// should be placed in front of the authentication middleware so that the
+// requests over the limit do not create sessions either. Requests over the
+// limit are rejected with a LUD-04 `ERROR` response.
+func (a *Auth) LimitChallenges(c *gin.Context) {
+	if !a.challengeLimiter.allow(a.clientIP(c.Request)) {
+		writeTooManyRequests(c.Writer, a.limits.Challenge)
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// HTTPLimitChallenges is the net/http equivalent of LimitChallenges.
+func (a *Auth) HTTPLimitChallenges(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.challengeLimiter.allow(a.clientIP(r)) {
+			writeTooManyRequests(w, a.limits.Challenge)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeTooManyRequests responds with a LUD-04 `ERROR` response telling the
+// client to retry once the bucket has gained a token.
+func writeTooManyRequests(w http.ResponseWriter, limit RateLimit) {
+	retryAfter := limit.Period / time.Duration(limit.Limit)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+
+	w.Header().Set("Retry-After", formatSeconds(retryAfter))
+	writeJSON(
+		w,
+		http.StatusTooManyRequests,
+		createErrorResponse(errTooManyRequests.Error()),
+	)
+}
+
+// formatSeconds formats the duration as a whole number of seconds, rounded
+// up.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
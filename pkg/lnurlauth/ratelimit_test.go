@@ -0,0 +1,70 @@
+package lnurlauth_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestCallbacksAreRateLimitedPerIP(t *testing.T) {
+	server := newHTTPServer(t, lnurlauth.WithRateLimits(lnurlauth.RateLimits{
+		Callback: lnurlauth.RateLimit{Limit: 2, Period: time.Minute},
+	}))
+	w := lnurlauthtest.NewWallet(t)
+	unknownK1 := strings.Repeat("ab", 32)
+
+	for i := 0; i < 2; i++ {
+		if err := w.Login(server.URL, unknownK1); err == nil ||
+			err.Error() != "unknown k1 challenge" {
+			t.Fatalf("expected unknown challenge, got %v", err)
+		}
+	}
+
+	if err := w.Login(server.URL, unknownK1); err == nil ||
+		err.Error() != "too many requests, try again later" {
+		t.Fatalf("expected rate limit, got %v", err)
+	}
+}
+
+func TestFailedSignaturesAreRateLimitedPerChallenge(t *testing.T) {
+	server := newHTTPServer(t, lnurlauth.WithRateLimits(lnurlauth.RateLimits{
+		FailedSignature: lnurlauth.RateLimit{Limit: 2, Period: time.Minute},
+	}))
+	browser := newBrowser()
+
+	var challenge lnurlauth.AuthChallenge
+	postJSON(t, browser, server.URL+"/api/challenge", &challenge)
+
+	// A signature of another k1 challenge does not verify.
+	w := lnurlauthtest.NewWallet(t)
+	signature, err := w.Sign(strings.Repeat("cd", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(server.URL + "/login?" + url.Values{
+			"tag": {"login"},
+			"k1":  {challenge.K1},
+			"sig": {signature},
+			"key": {w.LinkingKey()},
+		}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected bad request, got %d", res.StatusCode)
+		}
+	}
+
+	// The challenge is no longer verified, even with a valid signature.
+	if err := w.Login(server.URL, challenge.K1); err == nil ||
+		err.Error() != "too many requests, try again later" {
+		t.Fatalf("expected rate limit, got %v", err)
+	}
+}
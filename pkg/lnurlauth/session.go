@@ -0,0 +1,189 @@
+package lnurlauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// sessionTouchInterval is the minimum interval between updates of the last
+// seen time of a session, so that the store is not written on every request.
+// It is shortened to half the idle timeout if the idle timeout is shorter.
+const sessionTouchInterval = time.Minute
+
+// signIn signs the session in to the account with the linking key. If the
+// session is already signed in to the account, e.g. after linking a key, its
+// metadata is kept. The session ID was issued together with the challenge, as
+// done by RequestChallenge, so it is not known to anyone but the browser.
+func (a *Auth) signIn(
+	sessionID string,
+	accountID string,
+	linkingKey string,
+) error {
+	now := time.Now()
+
+	session, ok := a.store.Session(sessionID)
+	if !ok || session.AccountID != accountID {
+		session = Session{
+			ID:         sessionID,
+			AccountID:  accountID,
+			LinkingKey: linkingKey,
+			CreatedAt:  now,
+			LastSeenAt: now,
+		}
+	}
+	session.ExpiresAt = a.sessionExpiry(session, now)
+
+	return a.store.SetSession(session)
+}
+
+// sessionExpiry returns the time at which the session expires if its latest
+// activity happens at now.
+func (a *Auth) sessionExpiry(session Session, now time.Time) time.Time {
+	idle := now.Add(a.idleTimeout)
+	absolute := session.CreatedAt.Add(a.absoluteTimeout)
+	if absolute.Before(idle) {
+		return absolute
+	}
+	return idle
+}
+
+// authenticateSession returns the account ID of the session and records the
+// request as the latest activity of the session, which extends the idle
+// timeout. If the session is not signed in, it will return false in the
+// second return value.
+func (a *Auth) authenticateSession(
+	r *http.Request,
+	sessionID string,
+) (string, bool) {
+	session, ok := a.store.Session(sessionID)
+	if !ok {
+		return "", false
+	}
+
+	touchInterval := sessionTouchInterval
+	if a.idleTimeout/2 < touchInterval {
+		touchInterval = a.idleTimeout / 2
+	}
+
+	userAgent := r.UserAgent()
+	ip := a.clientIP(r)
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= touchInterval ||
+		session.UserAgent != userAgent ||
+		session.IP != ip {
+		session.LastSeenAt = now
+		session.UserAgent = userAgent
+		session.IP = ip
+		session.ExpiresAt = a.sessionExpiry(session, now)
+
+		// Failing to record the activity does not fail the request.
+		_ = a.store.SetSession(session)
+	}
+
+	return session.AccountID, true
+}
+
+// Sessions lists the active sessions of the account signed in to the session,
+// most recently seen first. The session itself is marked as current.
+func (a *Auth) Sessions(sessionID string) ([]SessionInfo, error) {
+	accountID, ok := a.AccountID(sessionID)
+	if !ok {
+		return nil, errNotSignedIn
+	}
+
+	sessions, err := a.store.SessionsByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, SessionInfo{
+			ID:         publicSessionID(session.ID),
+			Current:    session.ID == sessionID,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+		})
+	}
+
+	return infos, nil
+}
+
+// RevokeSession logs out the session with the public ID (SessionInfo.ID). The
+// session must belong to the same account as the session making the request.
+func (a *Auth) RevokeSession(sessionID string, publicID string) error {
+	accountID, ok := a.AccountID(sessionID)
+	if !ok {
+		return errNotSignedIn
+	}
+
+	session, ok, err := a.sessionByPublicID(accountID, publicID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("session not found")
+	}
+
+	return a.Logout(session.ID)
+}
+
+// sessionByPublicID finds the unexpired session of the account with the
+// public ID (SessionInfo.ID). If there is no such session, it will return
+// false in the second return value.
+func (a *Auth) sessionByPublicID(
+	accountID string,
+	publicID string,
+) (Session, bool, error) {
+	sessions, err := a.store.SessionsByAccount(accountID)
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	for _, session := range sessions {
+		if publicSessionID(session.ID) == publicID {
+			return session, true, nil
+		}
+	}
+
+	return Session{}, false, nil
+}
+
+// LogoutEverywhere logs out all sessions of the account signed in to the
+// session, including the session itself.
+func (a *Auth) LogoutEverywhere(sessionID string) error {
+	accountID, ok := a.AccountID(sessionID)
+	if !ok {
+		return errNotSignedIn
+	}
+
+	sessions, err := a.store.SessionsByAccount(accountID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if err := a.Logout(session.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publicSessionID derives the public identifier of the session from the
+// session ID. The session ID cannot be recovered from it.
+func publicSessionID(sessionID string) string {
+	digest := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(digest[:8])
+}
@@ -0,0 +1,48 @@
+package lnurlauth_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestSessionsExpireAfterIdleAndAbsoluteTimeouts(t *testing.T) {
+	server := newHTTPServer(t, lnurlauth.WithSessionTimeouts(
+		400*time.Millisecond,
+		1200*time.Millisecond,
+	))
+
+	// signedIn reports whether the browser is still signed in.
+	signedIn := func(browser *http.Client) bool {
+		var sessions []lnurlauth.SessionInfo
+		return getJSON(
+			t,
+			browser,
+			server.URL+"/api/sessions",
+			&sessions,
+		) == http.StatusOK
+	}
+
+	idle, active := newBrowser(), newBrowser()
+	start := time.Now()
+	signIn(t, lnurlauthtest.NewWallet(t), idle, server.URL)
+	signIn(t, lnurlauthtest.NewWallet(t), active, server.URL)
+
+	// Requests extend the idle timeout, but not beyond the absolute timeout.
+	for time.Since(start) < time.Second {
+		if !signedIn(active) {
+			t.Fatalf("expected active session after %s", time.Since(start))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if signedIn(idle) {
+		t.Fatal("expected idle session to expire")
+	}
+	time.Sleep(time.Until(start.Add(1400 * time.Millisecond)))
+	if signedIn(active) {
+		t.Fatal("expected active session to expire after absolute timeout")
+	}
+}
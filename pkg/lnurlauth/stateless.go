@@ -0,0 +1,184 @@
+package lnurlauth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	// timestampSize is the size of the issue time embedded in the challenge
+	// state.
+	timestampSize = 8
+
+	// statelessK1Label and statelessStateLabel are used to derive separate
+	// keys for authenticating k1 challenges and for sealing session IDs from
+	// a single server secret.
+	statelessK1Label    = "lnurlauth k1"
+	statelessStateLabel = "lnurlauth state"
+)
+
+// errInvalidState is returned when the state sent back with a stateless k1
+// challenge cannot be decoded or authenticated.
+var errInvalidState = errors.New("invalid challenge state")
+
+// statelessChallenger issues k1 challenges that can be validated without
+// storing them. The k1 challenge is an HMAC over the session ID, the action,
+// the issue time and a random nonce keyed with the server secret. These are
+// carried alongside the k1 challenge in the login URL as an opaque state, in
+// which the session ID and the action are encrypted so that the session ID is
+// not exposed through the QR code. Any server sharing the same secret can
+// validate the challenge. The used challenges are recorded in the shared store
+// by Auth, so that a challenge cannot be replayed on another server.
+type statelessChallenger struct {
+	// k1Key is the key for computing the HMAC of k1 challenges.
+	k1Key []byte
+
+	// stateAEAD encrypts and authenticates the session ID in the state.
+	stateAEAD cipher.AEAD
+
+	// ttl is the duration in which the challenge can be used after issued.
+	ttl time.Duration
+}
+
+// newStatelessChallenger is a constructor of statelessChallenger.
+func newStatelessChallenger(
+	secret []byte,
+	ttl time.Duration,
+) (*statelessChallenger, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("challenge secret must not be empty")
+	}
+
+	block, err := aes.NewCipher(deriveKey(secret, statelessStateLabel))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statelessChallenger{
+		k1Key:     deriveKey(secret, statelessK1Label),
+		stateAEAD: aead,
+		ttl:       ttl,
+	}, nil
+}
+
+// issue generates a new k1 challenge with the action for the session together
+// with the state which must be sent back with the k1 challenge to login.
+func (c *statelessChallenger) issue(
+	sessionID string,
+	action Action,
+) (issuedChallenge, error) {
+	nonce := make([]byte, c.stateAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return issuedChallenge{}, err
+	}
+
+	issuedAt := time.Now().Truncate(time.Second)
+	timestamp := make([]byte, timestampSize)
+	binary.BigEndian.PutUint64(timestamp, uint64(issuedAt.Unix()))
+
+	// The state consists of the nonce, the issue time and the encrypted
+	// payload.
+	payload := statelessPayload(sessionID, action)
+	state := append(nonce, timestamp...)
+	state = c.stateAEAD.Seal(state, nonce, payload, timestamp)
+
+	k1 := c.k1MAC(payload, timestamp, nonce)
+
+	return issuedChallenge{
+		k1:        hex.EncodeToString(k1),
+		state:     base64.RawURLEncoding.EncodeToString(state),
+		action:    action,
+		expiresAt: issuedAt.Add(c.ttl),
+	}, nil
+}
+
+// verify checks that the k1 challenge was issued by a server sharing the same
+// secret and has not expired. It returns the session ID and the action that
+// the challenge was issued for, and the time at which it was issued.
+func (c *statelessChallenger) verify(
+	k1 string,
+	state string,
+) (string, Action, time.Time, error) {
+	k1Bytes, err := hex.DecodeString(k1)
+	if err != nil {
+		return "", "", time.Time{}, errChallengeUnknown
+	}
+
+	stateBytes, err := base64.RawURLEncoding.DecodeString(state)
+	if err != nil {
+		return "", "", time.Time{}, errInvalidState
+	}
+
+	nonceSize := c.stateAEAD.NonceSize()
+	if len(stateBytes) < nonceSize+timestampSize+c.stateAEAD.Overhead() {
+		return "", "", time.Time{}, errInvalidState
+	}
+
+	nonce := stateBytes[:nonceSize]
+	timestamp := stateBytes[nonceSize : nonceSize+timestampSize]
+	sealed := stateBytes[nonceSize+timestampSize:]
+
+	payload, err := c.stateAEAD.Open(nil, nonce, sealed, timestamp)
+	if err != nil {
+		return "", "", time.Time{}, errInvalidState
+	}
+
+	if !hmac.Equal(k1Bytes, c.k1MAC(payload, timestamp, nonce)) {
+		return "", "", time.Time{}, errChallengeUnknown
+	}
+
+	issuedAt := time.Unix(int64(binary.BigEndian.Uint64(timestamp)), 0)
+	if time.Since(issuedAt) > c.ttl {
+		return "", "", time.Time{}, errChallengeExpired
+	}
+
+	// The payload is the action and the session ID separated by a colon.
+	// Actions never contain a colon.
+	actionBytes, sessionIDBytes, ok := bytes.Cut(payload, []byte(":"))
+	if !ok {
+		return "", "", time.Time{}, errInvalidState
+	}
+
+	return string(sessionIDBytes), Action(actionBytes), issuedAt, nil
+}
+
+// statelessPayload encodes the session ID and the action which are sealed in
+// the state.
+func statelessPayload(sessionID string, action Action) []byte {
+	return []byte(string(action) + ":" + sessionID)
+}
+
+// k1MAC computes the HMAC over the payload containing the session ID and the
+// action, the issue time and the nonce which is used as a k1 challenge.
+func (c *statelessChallenger) k1MAC(
+	payload []byte,
+	timestamp []byte,
+	nonce []byte,
+) []byte {
+	h := hmac.New(sha256.New, c.k1Key)
+	h.Write(payload)
+	h.Write(timestamp)
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// deriveKey derives a 32-byte key for a specific purpose from the secret.
+func deriveKey(secret []byte, label string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
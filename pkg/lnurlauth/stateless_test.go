@@ -0,0 +1,129 @@
+package lnurlauth_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestStatelessChallengesAreAcceptedOnceByAnyServer(t *testing.T) {
+	store := lnurlauth.NewMemoryStore()
+	opts := []lnurlauth.Option{
+		lnurlauth.WithStore(store),
+		lnurlauth.WithStatelessChallenges([]byte("secret")),
+	}
+	first := newHTTPServer(t, opts...)
+	second := newHTTPServer(t, opts...)
+	browser := newBrowser()
+
+	var challenge lnurlauth.AuthChallenge
+	postJSON(t, browser, first.URL+"/api/challenge", &challenge)
+	issued := loginURL(t, challenge)
+	if issued.Query().Get("state") == "" {
+		t.Fatalf("expected challenge state in %s", issued)
+	}
+
+	// withQuery returns the login URL on the server with a query parameter
+	// replaced.
+	withQuery := func(serverURL string, key string, value string) string {
+		u, _ := url.Parse(serverURL + issued.Path)
+		query := issued.Query()
+		query.Set(key, value)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	w := lnurlauthtest.NewWallet(t)
+	state := issued.Query().Get("state")
+	tampered := []byte(state)
+	if tampered[len(tampered)/2] == 'A' {
+		tampered[len(tampered)/2] = 'B'
+	} else {
+		tampered[len(tampered)/2] = 'A'
+	}
+	tamperedState := string(tampered)
+	if err := w.Callback(
+		withQuery(second.URL, "state", tamperedState),
+	); err == nil || err.Error() != "invalid challenge state" {
+		t.Fatalf("expected tampered state to be rejected, got %v", err)
+	}
+	if err := w.Callback(
+		withQuery(second.URL, "k1", strings.Repeat("ab", 32)),
+	); err == nil || err.Error() != "unknown k1 challenge" {
+		t.Fatalf("expected tampered k1 to be rejected, got %v", err)
+	}
+
+	// The challenge issued by the first server is accepted by the second one,
+	// and the session is signed in on both.
+	if err := w.Callback(
+		withQuery(second.URL, "k1", challenge.K1),
+	); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+	var sessions []lnurlauth.SessionInfo
+	if status := getJSON(
+		t,
+		browser,
+		first.URL+"/api/sessions",
+		&sessions,
+	); status != http.StatusOK || len(sessions) != 1 {
+		t.Fatalf("expected signed-in session, got %d %+v", status, sessions)
+	}
+
+	// The used challenge cannot be replayed on any server.
+	for _, serverURL := range []string{first.URL, second.URL} {
+		if err := w.Callback(
+			withQuery(serverURL, "k1", challenge.K1),
+		); err == nil || err.Error() != "k1 challenge has already been used" {
+			t.Fatalf("expected replay to be rejected, got %v", err)
+		}
+	}
+}
+
+func TestStatelessChallengesExpire(t *testing.T) {
+	server := newHTTPServer(
+		t,
+		lnurlauth.WithStatelessChallenges([]byte("secret")),
+		lnurlauth.WithChallengeTTL(time.Second),
+	)
+	browser := newBrowser()
+
+	var challenge lnurlauth.AuthChallenge
+	postJSON(t, browser, server.URL+"/api/challenge", &challenge)
+
+	// The issue time is kept in seconds, so the challenge expires within two
+	// seconds.
+	time.Sleep(2100 * time.Millisecond)
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Callback(loginURL(t, challenge).String()); err == nil ||
+		err.Error() != "k1 challenge has expired" {
+		t.Fatalf("expected expired challenge, got %v", err)
+	}
+}
+
+func TestStatelessChallengesAreEvicted(t *testing.T) {
+	server := newHTTPServer(
+		t,
+		lnurlauth.WithStatelessChallenges([]byte("secret")),
+		lnurlauth.WithMaxChallenges(1),
+	)
+
+	var first, second lnurlauth.AuthChallenge
+	postJSON(t, newBrowser(), server.URL+"/api/challenge", &first)
+	postJSON(t, newBrowser(), server.URL+"/api/challenge", &second)
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Callback(loginURL(t, first).String()); err == nil ||
+		err.Error() != "k1 challenge has expired" {
+		t.Fatalf("expected evicted challenge to expire, got %v", err)
+	}
+	if err := w.Callback(loginURL(t, second).String()); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+}
@@ -0,0 +1,155 @@
+package lnurlauth
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrChallengeNotFound is returned by Store.ConsumeChallenge when the k1
+// challenge does not exist, has expired or has already been consumed.
+var ErrChallengeNotFound = errors.New("k1 challenge not found")
+
+// Store is a storage backend of Auth. It keeps the outstanding k1 challenges,
+// the accounts of the users and the mappings between session ID and account
+// ID.
+// Implementing Store on a shared or persistent storage allows the sessions to
+// survive server restarts and to be shared between multiple server instances.
+type Store interface {
+	// IssueChallenge stores a newly generated k1 challenge with the action
+	// for the session. The challenge is no longer usable after the given
+	// time-to-live.
+	IssueChallenge(
+		k1 string,
+		sessionID string,
+		action Action,
+		ttl time.Duration,
+	) error
+
+	// ChallengeBySession returns the outstanding k1 challenge of the session
+	// with the action and its expiration time. If the session has no
+	// outstanding challenge with the action, it will return false in the third
+	// return value.
+	ChallengeBySession(
+		sessionID string,
+		action Action,
+	) (string, time.Time, bool)
+
+	// SessionByChallenge returns the session ID and the action that the k1
+	// challenge was issued for. If the challenge does not exist or has
+	// expired, it will return false in the third return value.
+	SessionByChallenge(k1 string) (string, Action, bool)
+
+	// ConsumeChallenge removes the k1 challenge so that it cannot be used
+	// again. It returns ErrChallengeNotFound if the challenge does not exist,
+	// has expired or has already been consumed. The check and the removal
+	// must be atomic across all servers sharing the store, so that only one
+	// of the concurrent callbacks with the same challenge can login.
+	ConsumeChallenge(k1 string) error
+
+	// SetChallengeStatus records the status of the k1 challenge. The record is
+	// removed after the given time-to-live.
+	SetChallengeStatus(
+		k1 string,
+		status ChallengeStatus,
+		ttl time.Duration,
+	) error
+
+	// ChallengeStatus returns the recorded status of the k1 challenge. If
+	// there is no record, it will return false in the second return value.
+	ChallengeStatus(k1 string) (ChallengeStatus, bool)
+
+	// MarkChallengeUsed records that the k1 challenge has been used to login.
+	// The record is removed after the given time-to-live. It returns false if
+	// the challenge has already been marked. Stateless k1 challenges are not
+	// kept in the store, so this record is what prevents a challenge from
+	// being used twice. The check and the update must therefore be atomic
+	// across all servers sharing the store.
+	MarkChallengeUsed(k1 string, ttl time.Duration) (bool, error)
+
+	// Session returns the signed-in session. If the session is not signed in
+	// or has expired, it will return false in the second return value.
+	Session(sessionID string) (Session, bool)
+
+	// SetSession creates or replaces the session. The session expires at its
+	// expiration time.
+	SetSession(session Session) error
+
+	// DeleteSession signs the session out.
+	DeleteSession(sessionID string) error
+
+	// SessionsByAccount returns all unexpired sessions signed in to the
+	// account.
+	SessionsByAccount(accountID string) ([]Session, error)
+
+	// Account returns the account with the ID. If the account does not exist,
+	// it will return false in the second return value.
+	Account(accountID string) (Account, bool)
+
+	// AccountByKey returns the account that the linking key belongs to. If the
+	// linking key is unknown, it will return false in the second return value.
+	AccountByKey(linkingKey string) (Account, bool)
+
+	// SaveAccount creates or replaces the account. The linking keys of the
+	// account are indexed so that the account can be found by any of them,
+	// and the keys removed from the account are no longer indexed. Accounts
+	// never expire.
+	SaveAccount(account Account) error
+}
+
+// SessionExpiryNotifier is an optional interface of Store for the stores that
+// can report the sessions they remove because the sessions have expired. Auth
+// registers a callback if its store implements it, so that expired sessions
+// are recorded in the audit log.
+type SessionExpiryNotifier interface {
+	// OnSessionExpired sets the function called with each session removed
+	// because it has expired. Sessions that are deleted or replaced before
+	// they expire are not reported. The function is called without holding
+	// any lock of the store.
+	OnSessionExpired(fn func(session Session))
+}
+
+// StoreStatsReporter is an optional interface of Store for the stores that
+// can report the number of entries they hold. The statistics are exposed as
+// metrics if the store of Auth implements it.
+type StoreStatsReporter interface {
+	// Stats returns the numbers of entries held by the store.
+	Stats() StoreStats
+}
+
+// sessionChallengeKey is the key of the reverse mapping from session ID and
+// action to k1 challenge.
+func sessionChallengeKey(sessionID string, action Action) string {
+	return sessionID + ":" + string(action)
+}
+
+// cloneAccount returns a copy of the account that does not share the linking
+// keys with the original, so that stores are not modified through the
+// accounts they return.
+func cloneAccount(account Account) Account {
+	account.LinkingKeys = append([]string(nil), account.LinkingKeys...)
+	return account
+}
+
+// accountSessionIndex is an index of the IDs of the sessions signed in to each
+// account, so that the sessions of an account are found without scanning all
+// sessions. It is not safe for concurrent use.
+type accountSessionIndex map[string]map[string]struct{}
+
+// add adds the session to the index of its account.
+func (i accountSessionIndex) add(session Session) {
+	sessionIDs, ok := i[session.AccountID]
+	if !ok {
+		sessionIDs = make(map[string]struct{})
+		i[session.AccountID] = sessionIDs
+	}
+	sessionIDs[session.ID] = struct{}{}
+}
+
+// remove removes the session from the index of its account.
+func (i accountSessionIndex) remove(session Session) {
+	sessionIDs := i[session.AccountID]
+	delete(sessionIDs, session.ID)
+	if len(sessionIDs) == 0 {
+		delete(i, session.AccountID)
+	}
+}
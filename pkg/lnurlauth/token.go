@@ -0,0 +1,289 @@
+package lnurlauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+)
+
+// Tokens are the bearer tokens issued for a signed-in session. Its JSON form
+// follows the access token response of OAuth 2.0.
+type Tokens struct {
+	// AccessToken is a signed JWT authenticating the user to APIs. It is valid
+	// until it expires or the session is signed out, whichever comes first.
+	AccessToken string `json:"access_token"`
+
+	// TokenType is always `Bearer`.
+	TokenType string `json:"token_type"`
+
+	// ExpiresIn is the lifetime of the access token in seconds.
+	ExpiresIn int `json:"expires_in"`
+
+	// RefreshToken is a signed JWT used to obtain new tokens. It can be used
+	// only while the session is signed in.
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenClaims are the claims of the access tokens and the refresh tokens. The
+// subject is the account ID.
+type TokenClaims struct {
+	jwt.RegisteredClaims
+
+	// SessionID is the public ID (SessionInfo.ID) of the session that the
+	// token was issued for. The session ID itself is never put in a token, as
+	// anyone holding the token can read it, and it would let them use the
+	// session cookie.
+	SessionID string `json:"sid"`
+
+	// LinkingKeys are the linking keys of the account at the time the token
+	// was issued.
+	LinkingKeys []string `json:"linking_keys,omitempty"`
+
+	// TokenType distinguishes access tokens from refresh tokens so that one
+	// cannot be used in place of the other.
+	TokenType string `json:"token_type"`
+}
+
+// tokenIssuer signs and verifies the bearer tokens.
+type tokenIssuer struct {
+	// key is the private key signing the tokens.
+	key crypto.Signer
+
+	// method is the JWT signing method of the key.
+	method jwt.SigningMethod
+
+	// accessTTL is the lifetime of access tokens.
+	accessTTL time.Duration
+
+	// refreshTTL is the lifetime of refresh tokens.
+	refreshTTL time.Duration
+}
+
+// newTokenIssuer is a constructor of tokenIssuer. The key must be either a
+// P-256 ECDSA key, signing with ES256, or an Ed25519 key, signing with EdDSA.
+func newTokenIssuer(
+	key crypto.Signer,
+	accessTTL time.Duration,
+	refreshTTL time.Duration,
+) (*tokenIssuer, error) {
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("token signing key must be on curve P-256")
+		}
+		method = jwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, errors.New("token signing key must be ECDSA or Ed25519")
+	}
+
+	if accessTTL <= 0 {
+		return nil, errors.New("access token ttl must be positive")
+	}
+
+	return &tokenIssuer{
+		key:        key,
+		method:     method,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}, nil
+}
+
+// issue signs a pair of access token and refresh token for the session of the
+// account.
+func (t *tokenIssuer) issue(
+	issuer string,
+	sessionID string,
+	account Account,
+) (Tokens, error) {
+	now := time.Now()
+
+	accessToken, err := t.sign(TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   account.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.accessTTL)),
+		},
+		SessionID:   publicSessionID(sessionID),
+		LinkingKeys: account.LinkingKeys,
+		TokenType:   tokenTypeAccess,
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	refreshToken, err := t.sign(TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   account.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.refreshTTL)),
+		},
+		SessionID: publicSessionID(sessionID),
+		TokenType: tokenTypeRefresh,
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return Tokens{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(t.accessTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// sign signs the claims with the key.
+func (t *tokenIssuer) sign(claims TokenClaims) (string, error) {
+	return jwt.NewWithClaims(t.method, claims).SignedString(t.key)
+}
+
+// verify verifies the signature, the expiration time, the issuer and the type
+// of the token and returns its claims.
+func (t *tokenIssuer) verify(
+	issuer string,
+	token string,
+	tokenType string,
+) (TokenClaims, error) {
+	var claims TokenClaims
+	if _, err := jwt.ParseWithClaims(
+		token,
+		&claims,
+		func(*jwt.Token) (interface{}, error) {
+			return t.key.Public(), nil
+		},
+		jwt.WithValidMethods([]string{t.method.Alg()}),
+	); err != nil {
+		return TokenClaims{}, err
+	}
+
+	if claims.Issuer != issuer {
+		return TokenClaims{}, errors.New("token issuer does not match")
+	}
+	if claims.TokenType != tokenType {
+		return TokenClaims{}, errors.New("token type does not match")
+	}
+
+	return claims, nil
+}
+
+// IssueTokens exchanges the signed-in session for a pair of access token and
+// refresh token. Bearer tokens must be enabled with WithAccessTokens.
+func (a *Auth) IssueTokens(sessionID string) (Tokens, error) {
+	if a.tokens == nil {
+		return Tokens{}, errors.New("access tokens are not enabled")
+	}
+
+	account, ok := a.Account(sessionID)
+	if !ok {
+		return Tokens{}, errNotSignedIn
+	}
+
+	return a.tokens.issue(a.hostname, sessionID, account)
+}
+
+// RefreshTokens issues a new pair of access token and refresh token in
+// exchange for the refresh token of the request. It fails if the session that
+// the refresh token was issued for has been signed out. The request is
+// recorded as the latest activity of the session, which extends its idle
+// timeout.
+func (a *Auth) RefreshTokens(
+	r *http.Request,
+	refreshToken string,
+) (Tokens, error) {
+	if a.tokens == nil {
+		return Tokens{}, errors.New("access tokens are not enabled")
+	}
+
+	claims, err := a.tokens.verify(a.hostname, refreshToken, tokenTypeRefresh)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	// The session may have been signed out since the refresh token was
+	// issued.
+	session, ok, err := a.sessionByPublicID(claims.Subject, claims.SessionID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if !ok {
+		return Tokens{}, errNotSignedIn
+	}
+	accountID, ok := a.authenticateSession(r, session.ID)
+	if !ok {
+		return Tokens{}, errNotSignedIn
+	}
+	account, ok := a.store.Account(accountID)
+	if !ok {
+		return Tokens{}, errNotSignedIn
+	}
+
+	return a.tokens.issue(a.hostname, session.ID, account)
+}
+
+// VerifyAccessToken verifies the access token and returns its claims.
+func (a *Auth) VerifyAccessToken(accessToken string) (TokenClaims, error) {
+	if a.tokens == nil {
+		return TokenClaims{}, errors.New("access tokens are not enabled")
+	}
+
+	return a.tokens.verify(a.hostname, accessToken, tokenTypeAccess)
+}
+
+// sessionFromBearerToken authenticates the request with the access token in
+// the `Authorization` header and returns the session ID and the account ID of
+// the token. The token only carries the public ID of the session, which is
+// resolved to the session in the store, so the token stops working once the
+// session is signed out. The request is recorded as the latest activity of the
+// session, the same as with the session cookie. If bearer tokens are disabled
+// or the request has no bearer token, it will return false in the third return
+// value so that the session cookie is used instead.
+func (a *Auth) sessionFromBearerToken(
+	r *http.Request,
+) (string, string, bool, error) {
+	if a.tokens == nil {
+		return "", "", false, nil
+	}
+
+	authorization := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(authorization) < len(prefix) ||
+		!strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", "", false, nil
+	}
+
+	claims, err := a.VerifyAccessToken(authorization[len(prefix):])
+	if err != nil {
+		return "", "", true, err
+	}
+
+	session, ok, err := a.sessionByPublicID(claims.Subject, claims.SessionID)
+	if err != nil {
+		return "", "", true, err
+	}
+	if !ok {
+		return "", "", true, errNotSignedIn
+	}
+	accountID, ok := a.authenticateSession(r, session.ID)
+	if !ok {
+		return "", "", true, errNotSignedIn
+	}
+
+	return session.ID, accountID, true, nil
+}
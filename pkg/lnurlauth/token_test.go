@@ -0,0 +1,272 @@
+package lnurlauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestAccessTokensFollowTheSession(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := lnurlauth.NewMemoryStore()
+	server := newGinServer(
+		t,
+		lnurlauth.WithStore(store),
+		lnurlauth.WithAccessTokens(key, time.Minute),
+	)
+
+	first, second := lnurlauthtest.NewWallet(t), lnurlauthtest.NewWallet(t)
+	browser, otherDevice, otherUser := newBrowser(), newBrowser(), newBrowser()
+	signIn(t, first, browser, server.URL)
+	signIn(t, first, otherDevice, server.URL)
+	signIn(t, second, otherUser, server.URL)
+
+	var tokens lnurlauth.Tokens
+	if status := postJSON(
+		t,
+		browser,
+		server.URL+"/api/token",
+		&tokens,
+	); status != http.StatusOK {
+		t.Fatalf("token: status %d", status)
+	}
+
+	// The token identifies the session by its public ID, never by the value
+	// of the session cookie.
+	var sessions []lnurlauth.SessionInfo
+	getJSON(t, browser, server.URL+"/api/sessions", &sessions)
+	var current lnurlauth.SessionInfo
+	for _, session := range sessions {
+		if session.Current {
+			current = session
+		}
+	}
+	if len(sessions) != 2 || current.ID == "" {
+		t.Fatalf("expected two sessions with the current one, got %+v", sessions)
+	}
+	for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+		claims := tokenClaims(t, token)
+		if claims["sid"] != current.ID ||
+			claims["sub"] != walletAccountID(t, store, first) {
+			t.Fatalf("unexpected claims %+v", claims)
+		}
+		if strings.Contains(
+			fmt.Sprint(claims),
+			sessionCookie(t, browser, server.URL),
+		) {
+			t.Fatalf("expected no session ID in claims %+v", claims)
+		}
+	}
+
+	// The bearer token takes precedence over the cookie of another account,
+	// and an invalid token is rejected even with a signed-in cookie.
+	status, page := bearerGet(t, otherUser, server.URL, tokens.AccessToken)
+	if status != http.StatusOK ||
+		page != indexPagePrefix+walletAccountID(t, store, first) {
+		t.Fatalf("expected index page of the token, got %d %q", status, page)
+	}
+	if status, _ := bearerGet(t, otherUser, server.URL, "invalid"); status !=
+		http.StatusUnauthorized {
+		t.Fatalf("expected invalid token to be rejected, got %d", status)
+	}
+
+	refreshed, status := refresh(t, server.URL, tokens.RefreshToken)
+	if status != http.StatusOK || refreshed.AccessToken == "" {
+		t.Fatalf("expected tokens to be refreshed, got %d", status)
+	}
+	if tokenClaims(t, refreshed.AccessToken)["sid"] != current.ID {
+		t.Fatal("expected refreshed token to keep the session")
+	}
+	if _, status := refresh(t, server.URL, tokens.AccessToken); status !=
+		http.StatusUnauthorized {
+		t.Fatalf("expected access token not to refresh, got %d", status)
+	}
+
+	// Revoking the session from another device ends its tokens.
+	if status := doJSON(
+		t,
+		otherDevice,
+		http.MethodDelete,
+		server.URL+"/api/sessions/"+current.ID,
+		&map[string]string{},
+	); status != http.StatusOK {
+		t.Fatalf("revoke: status %d", status)
+	}
+	if status, _ := bearerGet(
+		t,
+		newBrowser(),
+		server.URL,
+		refreshed.AccessToken,
+	); status != http.StatusUnauthorized {
+		t.Fatalf("expected token of revoked session to fail, got %d", status)
+	}
+	if _, status := refresh(t, server.URL, refreshed.RefreshToken); status !=
+		http.StatusUnauthorized {
+		t.Fatalf("expected refresh of revoked session to fail, got %d", status)
+	}
+	if page := getPage(t, browser, server.URL+"/"); page != loginPagePrefix {
+		t.Fatalf("expected revoked browser to be signed out, got %q", page)
+	}
+	getJSON(t, otherDevice, server.URL+"/api/sessions", &sessions)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("expected only the other device left, got %+v", sessions)
+	}
+}
+
+func TestBearerTokensKeepTheSessionAlive(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := lnurlauth.NewMemoryStore()
+	server := newGinServer(
+		t,
+		lnurlauth.WithStore(store),
+		lnurlauth.WithSessionTimeouts(600*time.Millisecond, time.Hour),
+		lnurlauth.WithAccessTokens(key, time.Minute),
+	)
+
+	// issueTokens signs a new browser in and exchanges its session for
+	// tokens.
+	issueTokens := func() (*http.Client, lnurlauth.Tokens) {
+		browser := newBrowser()
+		signIn(t, lnurlauthtest.NewWallet(t), browser, server.URL)
+
+		var tokens lnurlauth.Tokens
+		if status := postJSON(
+			t,
+			browser,
+			server.URL+"/api/token",
+			&tokens,
+		); status != http.StatusOK {
+			t.Fatalf("token: status %d", status)
+		}
+		return browser, tokens
+	}
+
+	idle, _ := issueTokens()
+	accessed, accessTokens := issueTokens()
+	refreshed, refreshTokens := issueTokens()
+
+	// Using the access token or the refresh token is activity of the session,
+	// so both sessions outlive the idle timeout without using the cookie.
+	start := time.Now()
+	for time.Since(start) < 1500*time.Millisecond {
+		if status, _ := bearerGet(
+			t,
+			newBrowser(),
+			server.URL,
+			accessTokens.AccessToken,
+		); status != http.StatusOK {
+			t.Fatalf("bearer: status %d after %s", status, time.Since(start))
+		}
+
+		tokens, status := refresh(t, server.URL, refreshTokens.RefreshToken)
+		if status != http.StatusOK {
+			t.Fatalf("refresh: status %d after %s", status, time.Since(start))
+		}
+		refreshTokens = tokens
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for name, browser := range map[string]*http.Client{
+		"accessed":  accessed,
+		"refreshed": refreshed,
+	} {
+		page := getPage(t, browser, server.URL+"/")
+		if page == loginPagePrefix {
+			t.Errorf("expected %s session to be kept alive", name)
+		}
+	}
+	if page := getPage(t, idle, server.URL+"/"); page != loginPagePrefix {
+		t.Errorf("expected idle session to expire, got %q", page)
+	}
+}
+
+// bearerGet requests the page of the server with the access token in the
+// browser and returns the status code and the body.
+func bearerGet(
+	t *testing.T,
+	browser *http.Client,
+	serverURL string,
+	accessToken string,
+) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	res, err := browser.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	dat, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(dat)
+}
+
+// refresh exchanges the refresh token for new tokens at the server and
+// returns the status code.
+func refresh(
+	t *testing.T,
+	serverURL string,
+	refreshToken string,
+) (lnurlauth.Tokens, int) {
+	t.Helper()
+
+	res, err := http.PostForm(
+		serverURL+"/api/token/refresh",
+		url.Values{"refresh_token": {refreshToken}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var refreshed lnurlauth.Tokens
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(&refreshed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return refreshed, res.StatusCode
+}
+
+// tokenClaims decodes the claims of the JWT without verifying it, as anyone
+// holding the token can.
+func tokenClaims(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed token %q", token)
+	}
+	dat, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(dat, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
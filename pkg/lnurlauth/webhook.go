@@ -0,0 +1,498 @@
+package lnurlauth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader is the header carrying the HMAC-SHA256 signature
+	// of the request body in the format `sha256=<hex>`.
+	WebhookSignatureHeader = "X-Lnurlauth-Signature"
+
+	// WebhookDeliveryHeader is the header carrying the ID of the notification,
+	// which stays the same across retries so that receivers can drop
+	// duplicates.
+	WebhookDeliveryHeader = "X-Lnurlauth-Delivery"
+)
+
+const (
+	defaultWebhookMaxAttempts    = 5
+	defaultWebhookInitialBackoff = time.Second
+	defaultWebhookMaxBackoff     = time.Minute
+	defaultWebhookTimeout        = time.Second * 10
+)
+
+// WebhookEventType is the type of a webhook notification.
+type WebhookEventType string
+
+const (
+	// WebhookEventLogin is sent when a wallet application has signed a k1
+	// challenge and the action of the challenge is performed.
+	WebhookEventLogin WebhookEventType = "login"
+
+	// WebhookEventLogout is sent when a signed-in session is logged out,
+	// including revoked sessions.
+	WebhookEventLogout WebhookEventType = "logout"
+)
+
+// WebhookPayload is the JSON body of a webhook notification.
+type WebhookPayload struct {
+	// ID is a unique ID of the notification. It is also sent in the
+	// WebhookDeliveryHeader header.
+	ID string `json:"id"`
+
+	// Event is the type of the notification.
+	Event WebhookEventType `json:"event"`
+
+	// LinkingKey is the linking key that the session signed in with.
+	LinkingKey string `json:"linkingKey"`
+
+	// AccountID is the ID of the account that the session is signed in to.
+	AccountID string `json:"accountId"`
+
+	// Session identifies the session without revealing the session ID. It is
+	// the same as the ID in SessionInfo.
+	Session string `json:"session"`
+
+	// Action is the LUD-04 action of the k1 challenge of a login.
+	Action Action `json:"action,omitempty"`
+
+	// Timestamp is the time at which the event happened. Receivers may reject
+	// old notifications to limit replays.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookDeadLetter is a notification that could not be delivered, kept in
+// the dead-letter file.
+type WebhookDeadLetter struct {
+	// Payload is the undelivered notification.
+	Payload WebhookPayload `json:"payload"`
+
+	// Attempts is the number of delivery attempts so far.
+	Attempts int `json:"attempts"`
+
+	// Error is the error of the latest attempt.
+	Error string `json:"error"`
+
+	// FailedAt is the time of the latest attempt.
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// WebhookConfig configures the delivery of webhook notifications to an
+// endpoint.
+type WebhookConfig struct {
+	// URL is the endpoint to which the notifications are posted.
+	URL string
+
+	// Secret is the key of the HMAC-SHA256 signature of the request body,
+	// sent in the WebhookSignatureHeader header.
+	Secret []byte
+
+	// MaxAttempts is the number of attempts to deliver a notification before
+	// it is moved to the dead-letter file. The default is 5.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. The wait doubles on
+	// each retry up to MaxBackoff. The defaults are one second and one
+	// minute.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// DeadLetterPath is the JSON Lines file to which the undelivered
+	// notifications are appended. If it is empty, they are logged and
+	// dropped.
+	DeadLetterPath string
+
+	// Client is the HTTP client sending the notifications. The default client
+	// times out after 10 seconds.
+	Client *http.Client
+}
+
+// WebhookNotifier posts webhook notifications to an endpoint. Notifications
+// are delivered in the background and retried with exponential backoff when
+// the endpoint fails or does not respond with a 2xx status. Notifications
+// that still cannot be delivered are kept in a dead-letter file, from which
+// they can be retried with RetryDeadLetters.
+type WebhookNotifier struct {
+	config WebhookConfig
+
+	// deadLetterMu guards the dead-letter file.
+	deadLetterMu sync.Mutex
+
+	// mu guards closed so that no delivery starts after Close.
+	mu     sync.Mutex
+	closed bool
+
+	// deliveries tracks the notifications being delivered and the retries
+	// of the dead letters.
+	deliveries sync.WaitGroup
+
+	// stop is closed by Close to abort the backoff of the pending deliveries
+	// and the retries of the dead letters.
+	stop chan struct{}
+}
+
+// NewWebhookNotifier is a constructor of WebhookNotifier.
+func NewWebhookNotifier(config WebhookConfig) (*WebhookNotifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook url must not be empty")
+	}
+	if len(config.Secret) == 0 {
+		return nil, errors.New("webhook secret must not be empty")
+	}
+
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if config.InitialBackoff == 0 {
+		config.InitialBackoff = defaultWebhookInitialBackoff
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	if config.MaxAttempts < 0 ||
+		config.InitialBackoff < 0 ||
+		config.MaxBackoff < 0 {
+		return nil, errors.New("webhook attempts and backoff must be positive")
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+
+	return &WebhookNotifier{
+		config: config,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// Notify delivers the notification in the background. A notification given
+// after Close is moved to the dead-letter file without being sent.
+func (n *WebhookNotifier) Notify(payload WebhookPayload) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		n.deadLetter(WebhookDeadLetter{
+			Payload:  payload,
+			Error:    "webhook notifier is closed",
+			FailedAt: time.Now(),
+		})
+		return
+	}
+	n.deliveries.Add(1)
+	n.mu.Unlock()
+
+	go func() {
+		defer n.deliveries.Done()
+		n.deliver(payload)
+	}()
+}
+
+// Close stops retrying the pending deliveries and the dead letters and waits
+// for them to finish. The deliveries that have not succeeded by then are moved
+// to the dead-letter file.
+func (n *WebhookNotifier) Close() error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.stop)
+	}
+	n.mu.Unlock()
+
+	n.deliveries.Wait()
+	return nil
+}
+
+// RetryDeadLetters attempts to deliver each notification in the dead-letter
+// file once. A notification is removed from the file only after it has been
+// delivered, and a failed attempt is recorded in place, so no notification is
+// lost if the process stops partway through. The file is not locked while a
+// notification is sent, so new dead letters can be appended in the meantime.
+// Close stops the retry after the notification being sent and waits for it.
+// The notifications left in the file are retried by the next call.
+func (n *WebhookNotifier) RetryDeadLetters() error {
+	if n.config.DeadLetterPath == "" {
+		return nil
+	}
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.deliveries.Add(1)
+	n.mu.Unlock()
+	defer n.deliveries.Done()
+
+	n.deadLetterMu.Lock()
+	deadLetters, err := n.readDeadLetters()
+	n.deadLetterMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, deadLetter := range deadLetters {
+		select {
+		case <-n.stop:
+			return nil
+		default:
+		}
+
+		id := deadLetter.Payload.ID
+		if err := n.send(deadLetter.Payload); err != nil {
+			deadLetter.Attempts++
+			deadLetter.Error = err.Error()
+			deadLetter.FailedAt = time.Now()
+			if err := n.replaceDeadLetter(id, &deadLetter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := n.replaceDeadLetter(id, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaceDeadLetter rewrites the dead-letter file with the notification of the
+// ID replaced by the dead letter, or removed if the dead letter is nil. The
+// other notifications, including the ones appended since the retry started,
+// are kept.
+func (n *WebhookNotifier) replaceDeadLetter(
+	id string,
+	deadLetter *WebhookDeadLetter,
+) error {
+	n.deadLetterMu.Lock()
+	defer n.deadLetterMu.Unlock()
+
+	current, err := n.readDeadLetters()
+	if err != nil {
+		return err
+	}
+
+	var deadLetters []WebhookDeadLetter
+	for _, other := range current {
+		if other.Payload.ID != id {
+			deadLetters = append(deadLetters, other)
+		} else if deadLetter != nil {
+			deadLetters = append(deadLetters, *deadLetter)
+		}
+	}
+
+	return n.writeDeadLetters(deadLetters)
+}
+
+// deliver sends the notification until it succeeds or the attempts run out,
+// waiting with exponential backoff between the attempts.
+func (n *WebhookNotifier) deliver(payload WebhookPayload) {
+	backoff := n.config.InitialBackoff
+
+	var err error
+	attempts := 0
+	for attempts < n.config.MaxAttempts {
+		attempts++
+		if err = n.send(payload); err == nil {
+			return
+		}
+		if attempts == n.config.MaxAttempts || !n.wait(backoff) {
+			break
+		}
+
+		backoff *= 2
+		if backoff > n.config.MaxBackoff {
+			backoff = n.config.MaxBackoff
+		}
+	}
+
+	n.deadLetter(WebhookDeadLetter{
+		Payload:  payload,
+		Attempts: attempts,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+	})
+}
+
+// wait waits for the backoff before the next attempt. It returns false if the
+// notifier is closed in the meantime.
+func (n *WebhookNotifier) wait(backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-n.stop:
+		return false
+	}
+}
+
+// send posts the notification to the endpoint once. Any response other than
+// 2xx is an error.
+func (n *WebhookNotifier) send(payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		n.config.URL,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, payload.ID)
+	req.Header.Set(
+		WebhookSignatureHeader,
+		SignWebhookPayload(n.config.Secret, body),
+	)
+
+	res, err := n.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// deadLetter appends the undelivered notification to the dead-letter file.
+// Without the file, the notification is logged and dropped.
+func (n *WebhookNotifier) deadLetter(deadLetter WebhookDeadLetter) {
+	if n.config.DeadLetterPath == "" {
+		log.Printf(
+			"webhook: dropped %s notification %s: %s",
+			deadLetter.Payload.Event,
+			deadLetter.Payload.ID,
+			deadLetter.Error,
+		)
+		return
+	}
+
+	n.deadLetterMu.Lock()
+	defer n.deadLetterMu.Unlock()
+
+	if err := n.appendDeadLetter(deadLetter); err != nil {
+		log.Printf("webhook: dead letter: %s", err.Error())
+	}
+}
+
+// appendDeadLetter writes the notification as a line at the end of the
+// dead-letter file. The caller must hold the lock.
+func (n *WebhookNotifier) appendDeadLetter(deadLetter WebhookDeadLetter) error {
+	line, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(
+		n.config.DeadLetterPath,
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+		0o600,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// readDeadLetters reads all notifications in the dead-letter file. The caller
+// must hold the lock.
+func (n *WebhookNotifier) readDeadLetters() ([]WebhookDeadLetter, error) {
+	file, err := os.Open(n.config.DeadLetterPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var deadLetters []WebhookDeadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var deadLetter WebhookDeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &deadLetter); err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, scanner.Err()
+}
+
+// writeDeadLetters replaces the content of the dead-letter file with the
+// notifications. The file is written to a temporary file first and then
+// renamed so that it is never left partially written. The caller must hold
+// the lock.
+func (n *WebhookNotifier) writeDeadLetters(
+	deadLetters []WebhookDeadLetter,
+) error {
+	var buf bytes.Buffer
+	for _, deadLetter := range deadLetters {
+		line, err := json.Marshal(deadLetter)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	tmpPath := n.config.DeadLetterPath + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, n.config.DeadLetterPath)
+}
+
+// SignWebhookPayload returns the value of the WebhookSignatureHeader header
+// for the request body, which receivers can compare with the header using
+// hmac.Equal.
+func SignWebhookPayload(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notify sends the webhook notification to every webhook notifier. The ID and
+// the time of the notification are set here.
+func (a *Auth) notify(payload WebhookPayload) {
+	if len(a.webhooks) == 0 {
+		return
+	}
+
+	payload.ID = random32BytesHex()
+	payload.Timestamp = time.Now()
+
+	for _, webhook := range a.webhooks {
+		webhook.Notify(payload)
+	}
+}
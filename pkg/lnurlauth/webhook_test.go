@@ -0,0 +1,435 @@
+package lnurlauth_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+)
+
+func TestWebhookNotifiesLoginAndLogout(t *testing.T) {
+	secret := []byte("webhook secret")
+	receiver, payloads := newWebhookReceiver(t, secret, 0)
+
+	notifier, err := lnurlauth.NewWebhookNotifier(lnurlauth.WebhookConfig{
+		URL:    receiver.URL,
+		Secret: secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Close()
+
+	store := lnurlauth.NewMemoryStore()
+	server := newHTTPServer(
+		t,
+		lnurlauth.WithStore(store),
+		lnurlauth.WithWebhook(notifier),
+	)
+	browser := newBrowser()
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Login(
+		server.URL,
+		createChallenge(t, browser, server.URL),
+	); err != nil {
+		t.Fatalf("login: %s", err)
+	}
+
+	res, err := browser.Get(server.URL + "/logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// Deliveries run concurrently, so they may arrive in any order.
+	received := map[lnurlauth.WebhookEventType]lnurlauth.WebhookPayload{}
+	for i := 0; i < 2; i++ {
+		select {
+		case payload := <-payloads:
+			received[payload.Event] = payload
+		case <-time.After(time.Second * 5):
+			t.Fatalf("expected two notifications, got %+v", received)
+		}
+	}
+
+	for _, event := range []lnurlauth.WebhookEventType{
+		lnurlauth.WebhookEventLogin,
+		lnurlauth.WebhookEventLogout,
+	} {
+		payload, ok := received[event]
+		if !ok ||
+			payload.LinkingKey != w.LinkingKey() ||
+			payload.AccountID != walletAccountID(t, store, w) ||
+			payload.Session == "" ||
+			payload.Timestamp.IsZero() {
+			t.Fatalf("unexpected %s notification %+v", event, payload)
+		}
+	}
+}
+
+func TestWebhookRetriesAndDeadLetters(t *testing.T) {
+	secret := []byte("webhook secret")
+
+	// The receiver fails the three attempts of the first delivery and the
+	// first retry of the dead letter.
+	receiver, payloads := newWebhookReceiver(t, secret, 4)
+
+	deadLetterPath := filepath.Join(t.TempDir(), "webhooks.jsonl")
+	notifier, err := lnurlauth.NewWebhookNotifier(lnurlauth.WebhookConfig{
+		URL:            receiver.URL,
+		Secret:         secret,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond * 10,
+		DeadLetterPath: deadLetterPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier.Notify(lnurlauth.WebhookPayload{
+		ID:    "delivery",
+		Event: lnurlauth.WebhookEventLogin,
+	})
+
+	// Wait for the delivery to give up.
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		if _, err := os.Stat(deadLetterPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the notification to be dead-lettered")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err := notifier.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadLetters := readDeadLetters(t, deadLetterPath)
+	if len(deadLetters) != 1 ||
+		deadLetters[0].Payload.ID != "delivery" ||
+		deadLetters[0].Attempts != 3 {
+		t.Fatalf("expected one dead letter, got %+v", deadLetters)
+	}
+
+	// The dead letters are retried by the notifier of the next start.
+	restarted, err := lnurlauth.NewWebhookNotifier(lnurlauth.WebhookConfig{
+		URL:            receiver.URL,
+		Secret:         secret,
+		DeadLetterPath: deadLetterPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+
+	if err := restarted.RetryDeadLetters(); err != nil {
+		t.Fatal(err)
+	}
+	if deadLetters := readDeadLetters(t, deadLetterPath); len(deadLetters) != 1 ||
+		deadLetters[0].Attempts != 4 {
+		t.Fatalf("expected the dead letter to be kept, got %+v", deadLetters)
+	}
+
+	if err := restarted.RetryDeadLetters(); err != nil {
+		t.Fatal(err)
+	}
+	if deadLetters := readDeadLetters(t, deadLetterPath); len(deadLetters) != 0 {
+		t.Fatalf("expected no dead letter, got %+v", deadLetters)
+	}
+
+	select {
+	case payload := <-payloads:
+		if payload.ID != "delivery" {
+			t.Fatalf("unexpected notification %+v", payload)
+		}
+	default:
+		t.Fatal("expected the dead letter to be delivered")
+	}
+}
+
+func TestWebhookDeadLettersAreAppendedDuringRetry(t *testing.T) {
+	// The receiver holds the first request until released and fails every
+	// request.
+	first := make(chan struct{}, 1)
+	first <- struct{}{}
+	received, release := make(chan struct{}), make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-first:
+				close(received)
+				<-release
+			default:
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+	))
+	defer receiver.Close()
+	released := false
+	defer func() {
+		if !released {
+			close(release)
+		}
+	}()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "webhooks.jsonl")
+	line, err := json.Marshal(lnurlauth.WebhookDeadLetter{
+		Payload:  lnurlauth.WebhookPayload{ID: "old"},
+		Attempts: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(
+		deadLetterPath,
+		append(line, '\n'),
+		0o600,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier, err := lnurlauth.NewWebhookNotifier(lnurlauth.WebhookConfig{
+		URL:            receiver.URL,
+		Secret:         []byte("webhook secret"),
+		MaxAttempts:    1,
+		DeadLetterPath: deadLetterPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Close()
+
+	retried := make(chan error)
+	go func() {
+		retried <- notifier.RetryDeadLetters()
+	}()
+	<-received
+
+	// The dead-letter file is not locked while the retry is in flight, and
+	// the retried notification stays in the file until it is delivered.
+	notifier.Notify(lnurlauth.WebhookPayload{
+		ID:    "new",
+		Event: lnurlauth.WebhookEventLogin,
+	})
+	deadline := time.Now().Add(time.Second * 5)
+	for len(readDeadLetters(t, deadLetterPath)) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the new notification to be dead-lettered")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	deadLetters := readDeadLetters(t, deadLetterPath)
+	if deadLetters[0].Payload.ID != "old" ||
+		deadLetters[1].Payload.ID != "new" {
+		t.Fatalf("expected both dead letters, got %+v", deadLetters)
+	}
+
+	close(release)
+	released = true
+	if err := <-retried; err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed attempt is recorded in place.
+	deadLetters = readDeadLetters(t, deadLetterPath)
+	if len(deadLetters) != 2 ||
+		deadLetters[0].Payload.ID != "old" ||
+		deadLetters[0].Attempts != 2 ||
+		deadLetters[1].Payload.ID != "new" {
+		t.Fatalf("expected both dead letters, got %+v", deadLetters)
+	}
+}
+
+func TestWebhookRetryStopsPartwayWithoutLosingDeadLetters(t *testing.T) {
+	// The receiver accepts every request, but holds the second one until
+	// released.
+	var mu sync.Mutex
+	var delivered []string
+	received, release := make(chan struct{}), make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(lnurlauth.WebhookDeliveryHeader)
+			if id == "second" {
+				close(received)
+				<-release
+			}
+			mu.Lock()
+			delivered = append(delivered, id)
+			mu.Unlock()
+		},
+	))
+	defer receiver.Close()
+	released := false
+	defer func() {
+		if !released {
+			close(release)
+		}
+	}()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "webhooks.jsonl")
+	var lines []byte
+	for _, id := range []string{"first", "second", "third"} {
+		line, err := json.Marshal(lnurlauth.WebhookDeadLetter{
+			Payload:  lnurlauth.WebhookPayload{ID: id},
+			Attempts: 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, append(line, '\n')...)
+	}
+	if err := os.WriteFile(deadLetterPath, lines, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier, err := lnurlauth.NewWebhookNotifier(lnurlauth.WebhookConfig{
+		URL:            receiver.URL,
+		Secret:         []byte("webhook secret"),
+		DeadLetterPath: deadLetterPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retried := make(chan error, 1)
+	go func() {
+		retried <- notifier.RetryDeadLetters()
+	}()
+	<-received
+
+	// Only the delivered notification has been removed, so a crash at this
+	// point loses nothing.
+	deadLetters := readDeadLetters(t, deadLetterPath)
+	if len(deadLetters) != 2 ||
+		deadLetters[0].Payload.ID != "second" ||
+		deadLetters[1].Payload.ID != "third" {
+		t.Fatalf("expected the undelivered dead letters, got %+v", deadLetters)
+	}
+
+	// Closing the notifier stops the retry, but waits for the notification
+	// being sent.
+	closed := make(chan error, 1)
+	go func() {
+		closed <- notifier.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("expected close to wait for the retry")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	released = true
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-retried; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 2 ||
+		delivered[0] != "first" ||
+		delivered[1] != "second" {
+		t.Fatalf("expected the retry to stop after second, got %v", delivered)
+	}
+	deadLetters = readDeadLetters(t, deadLetterPath)
+	if len(deadLetters) != 1 || deadLetters[0].Payload.ID != "third" {
+		t.Fatalf("expected the last dead letter left, got %+v", deadLetters)
+	}
+}
+
+// newWebhookReceiver starts a webhook endpoint verifying the signature of the
+// notifications. The first `failures` requests are rejected, and the payloads
+// of the accepted ones are sent to the returned channel.
+func newWebhookReceiver(
+	t *testing.T,
+	secret []byte,
+	failures int,
+) (*httptest.Server, <-chan lnurlauth.WebhookPayload) {
+	t.Helper()
+
+	var mu sync.Mutex
+	payloads := make(chan lnurlauth.WebhookPayload, 10)
+
+	receiver := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if !hmac.Equal(
+				[]byte(r.Header.Get(lnurlauth.WebhookSignatureHeader)),
+				[]byte(lnurlauth.SignWebhookPayload(secret, body)),
+			) {
+				t.Errorf("invalid webhook signature")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			mu.Lock()
+			failing := failures > 0
+			failures--
+			mu.Unlock()
+			if failing {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			var payload lnurlauth.WebhookPayload
+			if err := json.Unmarshal(body, &payload); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.Header.Get(lnurlauth.WebhookDeliveryHeader) != payload.ID {
+				t.Errorf("delivery header does not match payload")
+			}
+
+			payloads <- payload
+		},
+	))
+	t.Cleanup(receiver.Close)
+
+	return receiver, payloads
+}
+
+// readDeadLetters reads the dead-letter file of a webhook notifier.
+func readDeadLetters(
+	t *testing.T,
+	path string,
+) []lnurlauth.WebhookDeadLetter {
+	t.Helper()
+
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deadLetters []lnurlauth.WebhookDeadLetter
+	decoder := json.NewDecoder(bytes.NewReader(dat))
+	for decoder.More() {
+		var deadLetter lnurlauth.WebhookDeadLetter
+		if err := decoder.Decode(&deadLetter); err != nil {
+			t.Fatal(err)
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters
+}
@@ -0,0 +1,33 @@
+package oidc
+
+import "github.com/gin-gonic/gin"
+
+// Discovery is a Gin handler of the discovery endpoint. It should be served at
+// DiscoveryPath.
+func (p *Provider) Discovery(c *gin.Context) {
+	p.ServeDiscovery(c.Writer, c.Request)
+}
+
+// Authorize is a Gin handler of the authorization endpoint. It should be
+// served at AuthorizePath after the authentication middleware of lnurlauth.
+func (p *Provider) Authorize(c *gin.Context) {
+	p.ServeAuthorize(c.Writer, c.Request)
+}
+
+// Token is a Gin handler of the token endpoint. It should be served at
+// TokenPath.
+func (p *Provider) Token(c *gin.Context) {
+	p.ServeToken(c.Writer, c.Request)
+}
+
+// UserInfo is a Gin handler of the userinfo endpoint. It should be served at
+// UserInfoPath.
+func (p *Provider) UserInfo(c *gin.Context) {
+	p.ServeUserInfo(c.Writer, c.Request)
+}
+
+// JWKS is a Gin handler of the JSON Web Key Set. It should be served at
+// JWKSPath.
+func (p *Provider) JWKS(c *gin.Context) {
+	p.ServeJWKS(c.Writer, c.Request)
+}
@@ -0,0 +1,449 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+)
+
+// discoveryDocument is the OpenID Provider metadata served at the discovery
+// endpoint.
+type discoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// tokenResponse is the successful response of the token endpoint.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// idTokenClaims are the claims of an ID token.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce string `json:"nonce,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+// userInfo is the response of the userinfo endpoint.
+type userInfo struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name,omitempty"`
+}
+
+// ServeDiscovery responds with the OpenID Provider metadata.
+func (p *Provider) ServeDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, discoveryDocument{
+		Issuer:                 p.issuer,
+		AuthorizationEndpoint:  p.issuer + AuthorizePath,
+		TokenEndpoint:          p.issuer + TokenPath,
+		UserInfoEndpoint:       p.issuer + UserInfoPath,
+		JWKSURI:                p.issuer + JWKSPath,
+		ResponseTypesSupported: []string{"code"},
+		SubjectTypesSupported:  []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{
+			jwt.SigningMethodES256.Alg(),
+		},
+		ScopesSupported: []string{"openid", "profile"},
+		TokenEndpointAuthMethodsSupported: []string{
+			"client_secret_basic",
+			"client_secret_post",
+		},
+		GrantTypesSupported: []string{"authorization_code"},
+		ClaimsSupported: []string{
+			"iss",
+			"sub",
+			"aud",
+			"exp",
+			"iat",
+			"nonce",
+			"name",
+		},
+		CodeChallengeMethodsSupported: []string{"S256"},
+	})
+}
+
+// ServeAuthorize is the authorization endpoint. The request must have passed
+// through the authentication middleware of lnurlauth. If the end-user is not
+// signed in, it renders the LNURL-auth login page, which reloads the
+// authorization request once the user is signed in. Otherwise, it redirects
+// the end-user back to the relying party with an authorization code.
+func (p *Provider) ServeAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Errors in the client or the redirection URI must not be redirected to
+	// the redirection URI.
+	client, ok := p.clients[query.Get("client_id")]
+	if !ok {
+		writeError(
+			w,
+			http.StatusBadRequest,
+			"invalid_request",
+			"unknown client",
+		)
+		return
+	}
+	redirectURI := query.Get("redirect_uri")
+	if !contains(client.RedirectURIs, redirectURI) {
+		writeError(
+			w,
+			http.StatusBadRequest,
+			"invalid_request",
+			"redirect_uri is not registered",
+		)
+		return
+	}
+
+	state := query.Get("state")
+	redirectError := func(code string, description string) {
+		redirectWithParams(w, r, redirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {state},
+		})
+	}
+
+	if query.Get("response_type") != "code" {
+		redirectError(
+			"unsupported_response_type",
+			"only the code response type is supported",
+		)
+		return
+	}
+
+	scopes := strings.Fields(query.Get("scope"))
+	if !contains(scopes, "openid") {
+		redirectError("invalid_scope", "the openid scope is required")
+		return
+	}
+
+	codeChallenge := query.Get("code_challenge")
+	codeChallengeMethod := query.Get("code_challenge_method")
+	if codeChallenge != "" && codeChallengeMethod != "S256" {
+		redirectError(
+			"invalid_request",
+			"only the S256 code challenge method is supported",
+		)
+		return
+	}
+
+	sessionID, ok := lnurlauth.SessionIDFromContext(r.Context())
+	if !ok {
+		writeError(
+			w,
+			http.StatusInternalServerError,
+			"server_error",
+			"unexpected a request context with no session id",
+		)
+		return
+	}
+
+	// Let the end-user sign in with LNURL-auth first.
+	accountID, ok := p.auth.AccountID(sessionID)
+	if !ok {
+		p.renderLogin(w, r)
+		return
+	}
+
+	code := p.issueCode(authorization{
+		clientID:      client.ID,
+		redirectURI:   redirectURI,
+		accountID:     accountID,
+		scopes:        scopes,
+		nonce:         query.Get("nonce"),
+		codeChallenge: codeChallenge,
+	})
+
+	redirectWithParams(w, r, redirectURI, url.Values{
+		"code":  {code},
+		"state": {state},
+	})
+}
+
+// renderLogin renders the LNURL-auth login page with a challenge issued
+// together with a new session cookie.
+func (p *Provider) renderLogin(w http.ResponseWriter, r *http.Request) {
+	authChallenge, err := p.auth.RequestChallenge(
+		w,
+		r,
+		lnurlauth.ActionNone,
+	)
+	if err != nil {
+		writeError(
+			w,
+			http.StatusInternalServerError,
+			"server_error",
+			err.Error(),
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := p.loginTemplate.Execute(w, authChallenge); err != nil {
+		log.Printf("oidc: render login: %s", err.Error())
+	}
+}
+
+// ServeToken is the token endpoint. It exchanges an authorization code for an
+// access token and an ID token. The client authenticates with either HTTP
+// basic authentication or the form params `client_id` and `client_secret`.
+func (p *Provider) ServeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(
+			w,
+			http.StatusMethodNotAllowed,
+			"invalid_request",
+			"method must be POST",
+		)
+		return
+	}
+
+	client, ok := p.authenticateClient(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oidc"`)
+		writeError(
+			w,
+			http.StatusUnauthorized,
+			"invalid_client",
+			"client authentication failed",
+		)
+		return
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeError(
+			w,
+			http.StatusBadRequest,
+			"unsupported_grant_type",
+			"only the authorization_code grant type is supported",
+		)
+		return
+	}
+
+	authz, ok := p.exchangeCode(r.PostFormValue("code"))
+	if !ok ||
+		authz.clientID != client.ID ||
+		authz.redirectURI != r.PostFormValue("redirect_uri") {
+		writeError(
+			w,
+			http.StatusBadRequest,
+			"invalid_grant",
+			"authorization code is invalid",
+		)
+		return
+	}
+
+	if !verifyCodeChallenge(authz, r.PostFormValue("code_verifier")) {
+		writeError(
+			w,
+			http.StatusBadRequest,
+			"invalid_grant",
+			"code verifier does not match",
+		)
+		return
+	}
+
+	idToken, err := p.signIDToken(authz)
+	if err != nil {
+		writeError(
+			w,
+			http.StatusInternalServerError,
+			"server_error",
+			err.Error(),
+		)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken: p.issueAccessToken(authz),
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       strings.Join(authz.scopes, " "),
+	})
+}
+
+// authenticateClient authenticates the client of the token request. If the
+// client credentials are invalid, it will return false in the second return
+// value.
+func (p *Provider) authenticateClient(r *http.Request) (Client, bool) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+
+	client, ok := p.clients[clientID]
+	if !ok {
+		return Client{}, false
+	}
+
+	if subtle.ConstantTimeCompare(
+		[]byte(client.Secret),
+		[]byte(clientSecret),
+	) != 1 {
+		return Client{}, false
+	}
+
+	return client, true
+}
+
+// signIDToken creates an ID token of the authorization signed with the
+// signing key.
+func (p *Provider) signIDToken(authz authorization) (string, error) {
+	now := time.Now()
+	claims := idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.issuer,
+			Subject:   authz.accountID,
+			Audience:  jwt.ClaimStrings{authz.clientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(idTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Nonce: authz.nonce,
+	}
+	if authz.hasScope("profile") {
+		if account, ok := p.auth.AccountByID(authz.accountID); ok {
+			claims.Name = account.Profile.Name
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = p.keyID
+	return token.SignedString(p.signingKey)
+}
+
+// ServeUserInfo is the userinfo endpoint. It responds with the claims of the
+// end-user that the bearer access token was issued for.
+func (p *Provider) ServeUserInfo(w http.ResponseWriter, r *http.Request) {
+	accessToken := strings.TrimPrefix(
+		r.Header.Get("Authorization"),
+		"Bearer ",
+	)
+
+	authz, ok := p.authorizationByAccessToken(accessToken)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(
+			w,
+			http.StatusUnauthorized,
+			"invalid_token",
+			"access token is invalid",
+		)
+		return
+	}
+
+	info := userInfo{Subject: authz.accountID}
+	if authz.hasScope("profile") {
+		if account, ok := p.auth.AccountByID(authz.accountID); ok {
+			info.Name = account.Profile.Name
+		}
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+// ServeJWKS responds with the JSON Web Key Set containing the public key that
+// verifies the ID tokens.
+func (p *Provider) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]jwk{
+		"keys": {p.publicJWK()},
+	})
+}
+
+// verifyCodeChallenge verifies the PKCE (RFC 7636) code verifier against the
+// code challenge of the authorization. Authorizations without a code
+// challenge do not require a code verifier.
+func verifyCodeChallenge(authz authorization, codeVerifier string) bool {
+	if authz.codeChallenge == "" {
+		return true
+	}
+
+	digest := sha256.Sum256([]byte(codeVerifier))
+	return subtle.ConstantTimeCompare(
+		[]byte(base64.RawURLEncoding.EncodeToString(digest[:])),
+		[]byte(authz.codeChallenge),
+	) == 1
+}
+
+// redirectWithParams redirects the user agent to the URI with the params
+// added to its query. Empty params are omitted.
+func redirectWithParams(
+	w http.ResponseWriter,
+	r *http.Request,
+	uri string,
+	params url.Values,
+) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// writeError responds with an OAuth 2.0 error.
+func writeError(
+	w http.ResponseWriter,
+	status int,
+	code string,
+	description string,
+) {
+	writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// writeJSON responds with the value encoded in JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// contains reports whether the value is in the slice.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"html/template"
+)
+
+// Option is a functional option configuring Provider in NewProvider.
+type Option func(*options)
+
+// options contains the configurations of Provider.
+type options struct {
+	// clients are the registered relying parties.
+	clients []Client
+
+	// signingKey is the key signing ID tokens. If it is nil, a new key is
+	// generated.
+	signingKey *ecdsa.PrivateKey
+
+	// loginTemplate renders the login page.
+	loginTemplate *template.Template
+}
+
+// WithClient registers a relying party to the provider. It can be given
+// multiple times to register multiple relying parties.
+func WithClient(client Client) Option {
+	return func(o *options) {
+		o.clients = append(o.clients, client)
+	}
+}
+
+// WithSigningKey sets the P-256 key signing ID tokens. The key should be kept
+// across restarts so that the relying parties can still validate the ID tokens
+// issued before the restart.
+func WithSigningKey(key *ecdsa.PrivateKey) Option {
+	return func(o *options) {
+		o.signingKey = key
+	}
+}
+
+// WithLoginTemplate sets the template rendering the LNURL-auth login page at
+// the authorization endpoint. The template is executed with
+// lnurlauth.AuthChallenge and should reload the page once the session is
+// signed in, e.g. on the `verified` event of `/login/events`. This option is
+// required.
+func WithLoginTemplate(tmpl *template.Template) Option {
+	return func(o *options) {
+		o.loginTemplate = tmpl
+	}
+}
@@ -0,0 +1,280 @@
+// Package oidc implements an OpenID Connect provider
+// (https://openid.net/specs/openid-connect-core-1_0.html) on top of
+// LNURL-auth, so that off-the-shelf applications supporting OpenID Connect can
+// sign their users in with Lightning wallet applications. Only the
+// authorization code flow is supported.
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+)
+
+const (
+	// DiscoveryPath is the path of the discovery document relative to the
+	// issuer.
+	DiscoveryPath = "/.well-known/openid-configuration"
+
+	// AuthorizePath is the path of the authorization endpoint relative to the
+	// issuer.
+	AuthorizePath = "/oauth/authorize"
+
+	// TokenPath is the path of the token endpoint relative to the issuer.
+	TokenPath = "/oauth/token"
+
+	// UserInfoPath is the path of the userinfo endpoint relative to the
+	// issuer.
+	UserInfoPath = "/oauth/userinfo"
+
+	// JWKSPath is the path of the JSON Web Key Set relative to the issuer.
+	JWKSPath = "/oauth/jwks"
+)
+
+const (
+	codeTTL        = time.Minute
+	accessTokenTTL = time.Hour
+	idTokenTTL     = time.Hour
+)
+
+// Client is a relying party registered to the provider.
+type Client struct {
+	// ID is the client ID of the relying party.
+	ID string
+
+	// Secret is the client secret of the relying party.
+	Secret string
+
+	// RedirectURIs are the redirection URIs that the provider accepts from
+	// the relying party. The redirection URI in the authorization request
+	// must exactly match one of them.
+	RedirectURIs []string
+}
+
+// Provider is an OpenID Connect provider. The end-user is authenticated with
+// LNURL-auth at the authorization endpoint and the `sub` claim is the ID of
+// the account. It is derived from the linking key that registered the account
+// and stays the same whichever linking key of the account the end-user logs in
+// with.
+type Provider struct {
+	// auth is the LNURL-auth authentication service authenticating the
+	// end-user.
+	auth *lnurlauth.Auth
+
+	// issuer is the issuer identifier of the provider. The endpoints are
+	// served under the issuer.
+	issuer string
+
+	// clients are the registered relying parties by client ID.
+	clients map[string]Client
+
+	// signingKey is the key signing ID tokens with ES256.
+	signingKey *ecdsa.PrivateKey
+
+	// keyID is the JWK thumbprint of the signing key.
+	keyID string
+
+	// loginTemplate renders the login page when the end-user is not signed in
+	// at the authorization endpoint.
+	loginTemplate *template.Template
+
+	// codeMu serializes the exchanges of authorization codes so that each code
+	// can be used only once.
+	codeMu sync.Mutex
+
+	// codeCache is a storage of mappings between authorization code and
+	// authorization.
+	codeCache *cache.Cache
+
+	// accessTokenCache is a storage of mappings between access token and
+	// authorization.
+	accessTokenCache *cache.Cache
+}
+
+// authorization is an authorization granted by the end-user to a relying
+// party.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	accountID     string
+	scopes        []string
+	nonce         string
+	codeChallenge string
+}
+
+// hasScope reports whether the scope has been granted.
+func (a authorization) hasScope(scope string) bool {
+	return contains(a.scopes, scope)
+}
+
+// NewProvider is a constructor of Provider. The issuer must be the URL at
+// which the endpoints are served, without a trailing slash. If no signing key
+// is given, a new key is generated, which invalidates all issued ID tokens
+// when the server restarts.
+func NewProvider(
+	auth *lnurlauth.Auth,
+	issuer string,
+	opts ...Option,
+) (*Provider, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.loginTemplate == nil {
+		return nil, errors.New("login template is required")
+	}
+
+	signingKey := o.signingKey
+	if signingKey == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signingKey = key
+	}
+	if signingKey.Curve != elliptic.P256() {
+		return nil, errors.New("signing key must be on curve P-256")
+	}
+
+	clients := make(map[string]Client)
+	for _, client := range o.clients {
+		if client.ID == "" {
+			return nil, errors.New("client id is required")
+		}
+		clients[client.ID] = client
+	}
+
+	return &Provider{
+		auth:          auth,
+		issuer:        strings.TrimSuffix(issuer, "/"),
+		clients:       clients,
+		signingKey:    signingKey,
+		keyID:         jwkThumbprint(&signingKey.PublicKey),
+		loginTemplate: o.loginTemplate,
+		codeCache: cache.New(
+			cache.NoExpiration,
+			codeTTL,
+		),
+		accessTokenCache: cache.New(
+			cache.NoExpiration,
+			accessTokenTTL,
+		),
+	}, nil
+}
+
+// issueCode stores the authorization and returns the authorization code
+// representing it.
+func (p *Provider) issueCode(authz authorization) string {
+	code := randomToken()
+	p.codeCache.Set(code, authz, codeTTL)
+	return code
+}
+
+// exchangeCode returns the authorization of the authorization code and
+// removes the code so that it cannot be used again.
+func (p *Provider) exchangeCode(code string) (authorization, bool) {
+	p.codeMu.Lock()
+	defer p.codeMu.Unlock()
+
+	authzIntf, ok := p.codeCache.Get(code)
+	if !ok {
+		return authorization{}, false
+	}
+	p.codeCache.Delete(code)
+
+	authz, ok := authzIntf.(authorization)
+	return authz, ok
+}
+
+// issueAccessToken stores the authorization and returns the access token
+// representing it.
+func (p *Provider) issueAccessToken(authz authorization) string {
+	accessToken := randomToken()
+	p.accessTokenCache.Set(accessToken, authz, accessTokenTTL)
+	return accessToken
+}
+
+// authorizationByAccessToken finds the authorization of the access token.
+func (p *Provider) authorizationByAccessToken(
+	accessToken string,
+) (authorization, bool) {
+	authzIntf, ok := p.accessTokenCache.Get(accessToken)
+	if !ok {
+		return authorization{}, false
+	}
+
+	authz, ok := authzIntf.(authorization)
+	return authz, ok
+}
+
+// jwk is a JSON Web Key (RFC 7517) of an elliptic curve public key.
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// publicJWK returns the public key of the signing key as a JSON Web Key.
+func (p *Provider) publicJWK() jwk {
+	x, y := encodeCoordinates(&p.signingKey.PublicKey)
+	return jwk{
+		KeyType:   "EC",
+		Curve:     "P-256",
+		X:         x,
+		Y:         y,
+		Use:       "sig",
+		Algorithm: "ES256",
+		KeyID:     p.keyID,
+	}
+}
+
+// jwkThumbprint computes the JWK thumbprint (RFC 7638) of the public key,
+// which is used as the key ID.
+func jwkThumbprint(publicKey *ecdsa.PublicKey) string {
+	x, y := encodeCoordinates(publicKey)
+
+	// The members must be in lexicographic order without whitespace.
+	dat, _ := json.Marshal(struct {
+		Curve   string `json:"crv"`
+		KeyType string `json:"kty"`
+		X       string `json:"x"`
+		Y       string `json:"y"`
+	}{"P-256", "EC", x, y})
+
+	digest := sha256.Sum256(dat)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// encodeCoordinates encodes the coordinates of the P-256 public key as
+// fixed-length base64url strings.
+func encodeCoordinates(publicKey *ecdsa.PublicKey) (string, string) {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	publicKey.X.FillBytes(x)
+	publicKey.Y.FillBytes(y)
+	return base64.RawURLEncoding.EncodeToString(x),
+		base64.RawURLEncoding.EncodeToString(y)
+}
+
+// randomToken generates a random 32-byte token in base64url format.
+func randomToken() string {
+	data := make([]byte, 32)
+	_, _ = rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,385 @@
+package oidc_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth"
+	"github.com/sunboyy/lnurlauth/pkg/lnurlauth/lnurlauthtest"
+	"github.com/sunboyy/lnurlauth/pkg/oidc"
+)
+
+const (
+	clientID     = "relying-party"
+	clientSecret = "relying-party-secret"
+	codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// newTestProvider starts a server with the LNURL-auth login endpoint and the
+// endpoints of the OpenID Connect provider. The login page renders only the
+// k1 challenge so that the test can sign it.
+func newTestProvider(
+	t *testing.T,
+	redirectURI string,
+	opts ...lnurlauth.Option,
+) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	auth, err := lnurlauth.NewAuth(server.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := lnurlauth.NewHandler(auth)
+
+	provider, err := oidc.NewProvider(
+		auth,
+		server.URL,
+		oidc.WithClient(oidc.Client{
+			ID:           clientID,
+			Secret:       clientSecret,
+			RedirectURIs: []string{redirectURI},
+		}),
+		oidc.WithLoginTemplate(
+			template.Must(template.New("login").Parse("{{.K1}}")),
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux.HandleFunc("/login", handler.ServeLogin)
+	mux.HandleFunc(oidc.DiscoveryPath, provider.ServeDiscovery)
+	mux.Handle(
+		oidc.AuthorizePath,
+		auth.HTTPMiddleware(http.HandlerFunc(provider.ServeAuthorize)),
+	)
+	mux.HandleFunc(oidc.TokenPath, provider.ServeToken)
+	mux.HandleFunc(oidc.UserInfoPath, provider.ServeUserInfo)
+	mux.HandleFunc(oidc.JWKSPath, provider.ServeJWKS)
+
+	return server
+}
+
+// relyingParty is a stand-in of an application signing its users in with the
+// provider. It follows the authorization code flow with PKCE and verifies the
+// ID token against the published JWKS.
+type relyingParty struct {
+	issuer string
+	config struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	redirectURI string
+	state       string
+	nonce       string
+}
+
+// discover fetches the discovery document of the provider.
+func (rp *relyingParty) discover() error {
+	return getJSON(rp.issuer+oidc.DiscoveryPath, "", &rp.config)
+}
+
+// authorizeURL returns the URL of the authorization request.
+func (rp *relyingParty) authorizeURL() string {
+	digest := sha256.Sum256([]byte(codeVerifier))
+	codeChallenge := base64.RawURLEncoding.EncodeToString(digest[:])
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {rp.redirectURI},
+		"scope":                 {"openid profile"},
+		"state":                 {rp.state},
+		"nonce":                 {rp.nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return rp.config.AuthorizationEndpoint + "?" + query.Encode()
+}
+
+// handleCallback is the redirection endpoint. It exchanges the authorization
+// code, verifies the ID token and responds with the subject.
+func (rp *relyingParty) handleCallback(w http.ResponseWriter, r *http.Request) {
+	subject, err := rp.exchange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, _ = io.WriteString(w, subject)
+}
+
+// exchange exchanges the authorization code in the callback query for the
+// tokens and returns the subject after verifying the ID token and the
+// userinfo.
+func (rp *relyingParty) exchange(query url.Values) (string, error) {
+	if query.Get("state") != rp.state {
+		return "", errors.New("state does not match")
+	}
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := postToken(rp.config.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {query.Get("code")},
+		"redirect_uri":  {rp.redirectURI},
+		"code_verifier": {codeVerifier},
+	}, &tokens); err != nil {
+		return "", err
+	}
+
+	claims, err := rp.verifyIDToken(tokens.IDToken)
+	if err != nil {
+		return "", err
+	}
+
+	var info struct {
+		Subject string `json:"sub"`
+	}
+	if err := getJSON(
+		rp.config.UserInfoEndpoint,
+		tokens.AccessToken,
+		&info,
+	); err != nil {
+		return "", err
+	}
+	if info.Subject != claims.Subject {
+		return "", errors.New("userinfo subject does not match")
+	}
+
+	return claims.Subject, nil
+}
+
+// idTokenClaims are the claims of the ID token checked by the relying party.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce string `json:"nonce"`
+}
+
+// verifyIDToken verifies the signature and the claims of the ID token.
+func (rp *relyingParty) verifyIDToken(idToken string) (idTokenClaims, error) {
+	var jwks struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+			X     string `json:"x"`
+			Y     string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(rp.config.JWKSURI, "", &jwks); err != nil {
+		return idTokenClaims{}, err
+	}
+
+	var claims idTokenClaims
+	if _, err := jwt.ParseWithClaims(
+		idToken,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) {
+			for _, key := range jwks.Keys {
+				if key.KeyID != token.Header["kid"] {
+					continue
+				}
+				x, _ := base64.RawURLEncoding.DecodeString(key.X)
+				y, _ := base64.RawURLEncoding.DecodeString(key.Y)
+				return &ecdsa.PublicKey{
+					Curve: elliptic.P256(),
+					X:     new(big.Int).SetBytes(x),
+					Y:     new(big.Int).SetBytes(y),
+				}, nil
+			}
+			return nil, errors.New("unknown key id")
+		},
+		jwt.WithValidMethods([]string{"ES256"}),
+	); err != nil {
+		return idTokenClaims{}, err
+	}
+
+	if claims.Issuer != rp.issuer {
+		return idTokenClaims{}, errors.New("issuer does not match")
+	}
+	if !claims.VerifyAudience(clientID, true) {
+		return idTokenClaims{}, errors.New("audience does not match")
+	}
+	if claims.Nonce != rp.nonce {
+		return idTokenClaims{}, errors.New("nonce does not match")
+	}
+
+	return claims, nil
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	rp := &relyingParty{state: "state-1234", nonce: "nonce-5678"}
+	rpServer := httptest.NewServer(http.HandlerFunc(rp.handleCallback))
+	defer rpServer.Close()
+	rp.redirectURI = rpServer.URL + "/callback"
+
+	store := lnurlauth.NewMemoryStore()
+	server := newTestProvider(t, rp.redirectURI, lnurlauth.WithStore(store))
+	rp.issuer = server.URL
+	if err := rp.discover(); err != nil {
+		t.Fatalf("discover: %s", err)
+	}
+
+	// The browser keeps the session cookie of the provider.
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+
+	// The end-user is not signed in, so the login page is rendered.
+	loginPage, err := getBody(browser, rp.authorizeURL())
+	if err != nil {
+		t.Fatalf("authorize: %s", err)
+	}
+	k1 := strings.TrimSpace(loginPage)
+	if len(k1) != 64 {
+		t.Fatalf("expected k1 challenge on the login page, got %q", loginPage)
+	}
+
+	w := lnurlauthtest.NewWallet(t)
+	if err := w.Login(server.URL, k1); err != nil {
+		t.Fatalf("wallet login: %s", err)
+	}
+
+	// The login page reloads the authorization request once signed in, which
+	// redirects the browser to the relying party.
+	subject, err := getBody(browser, rp.authorizeURL())
+	if err != nil {
+		t.Fatalf("authorize after login: %s", err)
+	}
+
+	account, ok := store.AccountByKey(w.LinkingKey())
+	if !ok {
+		t.Fatal("expected linking key to be registered")
+	}
+	if subject != account.ID {
+		t.Errorf("expected subject %q, got %q", account.ID, subject)
+	}
+}
+
+func TestAuthorizeRejectsUnregisteredRedirectURI(t *testing.T) {
+	server := newTestProvider(t, "https://rp.example.com/callback")
+
+	res, err := http.Get(server.URL + oidc.AuthorizePath + "?" + url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {"https://attacker.example.com/callback"},
+		"scope":         {"openid"},
+	}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf(
+			"expected status %d, got %d",
+			http.StatusBadRequest,
+			res.StatusCode,
+		)
+	}
+}
+
+func TestTokenRejectsUnknownCode(t *testing.T) {
+	server := newTestProvider(t, "https://rp.example.com/callback")
+
+	var body map[string]string
+	err := postToken(server.URL+oidc.TokenPath, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"unknown-code"},
+		"redirect_uri": {"https://rp.example.com/callback"},
+	}, &body)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("expected invalid_grant error, got %v", err)
+	}
+}
+
+// getBody requests the URL and returns the response body. Non-2xx responses
+// are returned as errors.
+func getBody(client *http.Client, u string) (string, error) {
+	res, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	dat, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode/100 != 2 {
+		return "", fmt.Errorf("status %d: %s", res.StatusCode, dat)
+	}
+
+	return string(dat), nil
+}
+
+// getJSON requests the URL with the optional bearer token and decodes the
+// JSON response.
+func getJSON(u string, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	return doJSON(req, v)
+}
+
+// postToken requests the token endpoint with the client credentials in HTTP
+// basic authentication and decodes the JSON response.
+func postToken(u string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		u,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+
+	return doJSON(req, v)
+}
+
+// doJSON sends the request and decodes the JSON response. Non-2xx responses
+// are returned as errors.
+func doJSON(req *http.Request, v interface{}) error {
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	dat, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d: %s", res.StatusCode, dat)
+	}
+
+	return json.Unmarshal(dat, v)
+}